@@ -0,0 +1,39 @@
+package paxos
+
+//
+// choosing the seqs Append() proposes in.
+//
+// by default Append() tries one past the highest instance
+// this peer knows of, and if another value wins there,
+// the next one up, and so on. appenders on different peers
+// race for the same seqs that way, and the losers spend a
+// round for nothing each time. with Config.SeqAllocator,
+// Append() asks it for a seq instead, on every try, so an
+// allocator that hands each appender its own seqs (say,
+// ranges leased out by the leader) avoids the races.
+//
+
+//
+// a source of seqs for Append() to propose in. the seqs
+// it hands out must be ones no other appender will try at
+// the same time, or Append()s will collide as they do by
+// default. Next is called again after a collision, so it
+// mustn't hand out the same seq twice.
+//
+type SeqAllocator interface {
+	Next() int
+}
+
+// the seq for Append()'s next try, after last (-1 before
+// the first).
+func (px *Paxos) nextAppendSeq(last int) int {
+	if px.config.SeqAllocator != nil {
+		return px.config.SeqAllocator.Next()
+	}
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	if next := px.nextSeqLocked(); next > last+1 {
+		return next
+	}
+	return last + 1
+}
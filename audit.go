@@ -0,0 +1,78 @@
+package paxos
+
+//
+// an audit trail of what this peer agreed to, for
+// Config.Audit.
+//
+// each promise this peer grants, each value it accepts and
+// each decision it learns is reported to the sink as it
+// happens, with the seq, the pnum and a hash of the value,
+// so the sink can keep an append-only record to check the
+// peer's conduct against later. with no sink, all this
+// costs is a nil check.
+//
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+type AuditKind int
+
+const (
+	AuditPrepareGranted AuditKind = iota + 1 // promised not to accept below PNum
+	AuditAcceptGranted                       // accepted the value with PNum
+	AuditDecided                             // learned the value with PNum was decided
+)
+
+func (k AuditKind) String() string {
+	switch k {
+	case AuditPrepareGranted:
+		return "PrepareGranted"
+	case AuditAcceptGranted:
+		return "AcceptGranted"
+	case AuditDecided:
+		return "Decided"
+	}
+	return fmt.Sprintf("AuditKind(%d)", int(k))
+}
+
+type AuditEvent struct {
+	Kind      AuditKind
+	Time      time.Time // by Config.Clock
+	Seq       int
+	PNum      string
+	ValueHash string // hex SHA-256 of the value; "" for a Prepare, which has none
+}
+
+//
+// where Config.Audit sends events. AuditLog is called with
+// px.mu held, in the order the events happen, so it must be
+// quick and mustn't call back into the Paxos.
+//
+type AuditSink interface {
+	AuditLog(event AuditEvent)
+}
+
+// a hash of v that's the same on every peer, for values
+// that print the same on every peer, as values made of
+// strings, numbers, slices, maps and structs of them do.
+func hashValue(v interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%T %#v", v, v)))
+	return hex.EncodeToString(sum[:])
+}
+
+// report an event for seq to Config.Audit, if there is one.
+// caller must hold px.mu.
+func (px *Paxos) auditLocked(kind AuditKind, seq int, pnum string, v interface{}) {
+	if px.config.Audit == nil {
+		return
+	}
+	e := AuditEvent{Kind: kind, Time: px.clock.Now(), Seq: seq, PNum: pnum}
+	if kind != AuditPrepareGranted {
+		e.ValueHash = hashValue(v)
+	}
+	px.config.Audit.AuditLog(e)
+}
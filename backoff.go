@@ -0,0 +1,53 @@
+package paxos
+
+//
+// randomized backoff, so that proposers that keep preempting
+// each other (duelling) drift apart rather than retrying in
+// lockstep. each peer draws its delays from its own RNG,
+// seeded from Config.BackoffSeed or, by default, from process
+// entropy, with its index mixed in either way, so peers that
+// share a Config, or start at the same instant, still pick
+// different delays.
+//
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"time"
+)
+
+// set up px's backoff RNG from seed, or from process
+// entropy if seed is 0.
+func (px *Paxos) seedBackoff(seed int64) {
+	if seed == 0 {
+		var b [8]byte
+		if _, err := crand.Read(b[:]); err == nil {
+			seed = int64(binary.LittleEndian.Uint64(b[:]))
+		} else {
+			seed = time.Now().UnixNano()
+		}
+	}
+	px.seed = seed
+	px.rng = rand.New(rand.NewSource(seed + int64(px.me)))
+}
+
+//
+// the seed this peer's backoff RNG was made from, before
+// its index was mixed in. passing it back in as
+// Config.BackoffSeed to a peer with the same index gets
+// the same delays again.
+//
+func (px *Paxos) BackoffSeed() int64 {
+	return px.seed
+}
+
+// a random duration in [0, max), from px's backoff RNG.
+func (px *Paxos) randDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	px.rngMu.Lock()
+	defer px.rngMu.Unlock()
+	return time.Duration(px.rng.Int63n(int64(max)))
+}
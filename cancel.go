@@ -0,0 +1,77 @@
+package paxos
+
+//
+// cancelling a proposal by seq, for callers that no longer
+// need it but don't hold a context that would stop it.
+//
+// each running proposer registers a channel under its seq,
+// and looks at it between rounds. CancelProposal() closes
+// the channels of every proposer for the seq, which then
+// give up with ErrCancelled once their current round ends.
+//
+
+// register a proposer for seq, returning the channel that's
+// closed if it's cancelled.
+func (px *Paxos) registerProposal(seq int) chan struct{} {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	ch := make(chan struct{})
+	if px.proposals == nil {
+		px.proposals = map[int][]chan struct{}{}
+	}
+	px.proposals[seq] = append(px.proposals[seq], ch)
+	return ch
+}
+
+// forget a proposer for seq that has finished.
+func (px *Paxos) unregisterProposal(seq int, ch chan struct{}) {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	chs := px.proposals[seq]
+	for i, c := range chs {
+		if c == ch {
+			chs = append(chs[:i], chs[i+1:]...)
+			break
+		}
+	}
+	if len(chs) == 0 {
+		delete(px.proposals, seq)
+	} else {
+		px.proposals[seq] = chs
+	}
+}
+
+//
+// stop this peer's proposers for seq, if any, after their
+// current rounds. they return ErrCancelled, as do the
+// Propose()s waiting on them; ones still queued behind
+// Config.MaxProposers never start. it doesn't stop seq
+// being decided: a round that's already under way may
+// still win, and other peers may propose for seq
+// themselves.
+//
+func (px *Paxos) CancelProposal(seq int) {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	for _, ch := range px.proposals[seq] {
+		close(ch)
+	}
+	delete(px.proposals, seq)
+	queued := px.queued[:0]
+	for _, q := range px.queued {
+		if q.seq != seq {
+			queued = append(queued, q)
+		}
+	}
+	px.queued = queued
+}
+
+// has the proposal with cancel channel ch been cancelled?
+func cancelled(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
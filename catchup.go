@@ -0,0 +1,223 @@
+package paxos
+
+//
+// Catch-up: a peer that missed decisions, e.g. because it
+// was partitioned or deaf, fetches them from the others
+// rather than waiting for someone to propose each one again.
+//
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// how many instances to ask each peer for at a time.
+const catchUpBatch = 100
+
+type FetchArgs struct {
+	From    int // first seq wanted
+	To      int // last seq wanted
+	GroupID int
+}
+
+type FetchReply struct {
+	Err     string
+	Max     int          // highest seq the peer knows of, or -1
+	Entries []FetchEntry // decided instances in [From, To] the peer still has
+}
+
+type FetchEntry struct {
+	Seq   int
+	PNum  string
+	Value interface{}
+}
+
+// return the decided instances in a range.
+func (px *Paxos) Fetch(args *FetchArgs, reply *FetchReply) (err error) {
+	defer px.recoverHandler("Paxos.Fetch", &err)
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	reply.Err = OK
+	reply.Max = -1
+	for seq, instance := range px.instances {
+		if seq > reply.Max {
+			reply.Max = seq
+		}
+		if seq >= args.From && seq <= args.To && instance.state == Decided {
+			reply.Entries = append(reply.Entries, FetchEntry{seq, instance.n_a, px.packValue(instance.v_a)})
+		}
+	}
+	for seq := range px.spilled {
+		if seq > reply.Max {
+			reply.Max = seq
+		}
+		if seq >= args.From && seq <= args.To {
+			if instance, ok := px.lookupLocked(seq); ok {
+				reply.Entries = append(reply.Entries, FetchEntry{seq, instance.n_a, px.packValue(instance.v_a)})
+			}
+		}
+	}
+	return nil
+}
+
+//
+// learn the decided instances this peer has missed from the
+// other peers. all of them are asked at once, a batch at a
+// time, and their answers merged, so recovery goes as fast
+// as the fastest peers that have each instance: each answer
+// is learned from as it comes, and once the whole batch is
+// decided here the next one is asked for without waiting
+// for slower peers. peers that have forgotten part of the
+// range, or never learned it, just return less; by Paxos's
+// safety they can't disagree. returns the number of
+// instances learned.
+//
+func (px *Paxos) CatchUp() int {
+	learned, _ := px.CatchUpContext(context.Background(), nil)
+	return learned
+}
+
+//
+// like CatchUp(), but gives up when ctx is done, returning
+// what it learned by then and ctx's error; what was learned
+// stays learned. if progress isn't nil, it's called after
+// each batch with how many of the seqs from Min() up to the
+// highest one the other peers know of have been fetched so
+// far, and how many there are, which can grow as it goes.
+//
+func (px *Paxos) CatchUpContext(ctx context.Context, progress func(done, total int)) (int, error) {
+	learned := 0
+	start := px.Min()
+	for from := start; ; from += catchUpBatch {
+		if err := ctx.Err(); err != nil {
+			return learned, err
+		}
+		args := FetchArgs{From: from, To: from + catchUpBatch - 1, GroupID: px.group}
+		// buffered, so a cancelled catch-up leaves no one blocked.
+		replies := make(chan *FetchReply, len(px.peers))
+		for i := range px.peers {
+			if i == px.me {
+				continue
+			}
+			go func(i int) {
+				reply := &FetchReply{}
+				if !px.invoke(i, "Paxos.Fetch", &args, reply) || reply.Err != OK {
+					reply = nil
+				}
+				replies <- reply
+			}(i)
+		}
+
+		top := -1
+		filled := false
+		for n := 0; n < len(px.peers)-1 && !filled; n++ {
+			var reply *FetchReply
+			select {
+			case reply = <-replies:
+			case <-ctx.Done():
+				return learned, ctx.Err()
+			}
+			if reply == nil {
+				continue
+			}
+			if reply.Max > top {
+				top = reply.Max
+			}
+			learned += px.learn(reply.Entries)
+			filled = px.haveDecided(args.From, args.To)
+		}
+		if progress != nil && top >= start {
+			done := args.To
+			if done > top {
+				done = top
+			}
+			progress(done-start+1, top-start+1)
+		}
+		// a filled batch may not be the last, even if the
+		// peers that answered know of nothing past it.
+		if top <= args.To && !filled {
+			return learned, nil
+		}
+	}
+}
+
+// is every seq from from to to decided here, or forgotten?
+func (px *Paxos) haveDecided(from, to int) bool {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	if min := px.lowestDoneLocked() + 1; from < min {
+		from = min
+	}
+	for seq := from; seq <= to; seq++ {
+		if instance, ok := px.lookupLocked(seq); !ok || instance.state != Decided {
+			return false
+		}
+	}
+	return true
+}
+
+// record decisions fetched from another peer, returning how
+// many were news.
+func (px *Paxos) learn(entries []FetchEntry) int {
+	defer px.syncWAL()
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	n := 0
+	for _, e := range entries {
+		if instance, ok := px.lookupLocked(e.Seq); ok && instance.state == Decided {
+			continue
+		}
+		if e.Seq > px.lowestDoneLocked() {
+			px.decideLocked(e.Seq, e.PNum, unpackValue(e.Value))
+			n++
+		}
+	}
+	return n
+}
+
+// a decision for InstallDecided().
+type DecidedValue struct {
+	PNum string
+	V    interface{}
+}
+
+//
+// mark each seq in entries Decided with its value and pnum,
+// straight into this peer's state without any agreement,
+// to prime a fresh peer for a test or a read replica. this
+// bypasses every safety check, and Config.MaxInstances: the
+// caller is responsible for the entries being what the
+// group actually decided, and a wrong one will be served as
+// decided. it overwrites what's here already, except that
+// forgotten seqs stay forgotten. it raises Max() but leaves
+// Done() alone, since the application hasn't seen them yet.
+//
+func (px *Paxos) InstallDecided(entries map[int]DecidedValue) {
+	defer px.syncWAL()
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	for seq, e := range entries {
+		if seq <= px.lowestDoneLocked() {
+			continue
+		}
+		instance, err := px.instanceLocked(seq, false)
+		if err != nil {
+			px.logf("Paxos(%v) instance %v: %v", px.me, seq, err)
+			continue
+		}
+		instance.state = Decided
+		instance.v_a = e.V
+		instance.n_a = e.PNum
+		instance.n_p = e.PNum
+		if instance.decidedAt.IsZero() {
+			instance.decidedAt = px.clock.Now()
+			atomic.AddInt64(&px.decides, 1)
+		}
+		px.logInstanceLocked(seq)
+		px.notifyLocked(seq)
+	}
+}
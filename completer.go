@@ -0,0 +1,57 @@
+package paxos
+
+//
+// finishing instances whose proposer died mid-round.
+//
+// a proposer that gets its value accepted by a majority
+// and then crashes before sending Decide leaves the value
+// chosen but nobody knowing it: every peer has it Pending,
+// and it stays that way until someone proposes for it
+// again. with Config.CompleteAfter set, each peer looks
+// for instances it has accepted a value for but that have
+// seen no Prepare or Accept for that long, and proposes
+// for them itself. the proposal finds the accepted value
+// and so re-chooses it, and its Decide tells everyone.
+//
+
+import "time"
+
+// look for stalled instances every interval, until killed.
+func (px *Paxos) completer(interval time.Duration) {
+	for px.isdead() == false {
+		<-px.clock.After(interval)
+		if px.isdead() {
+			return
+		}
+		// one refused with ErrBusy is still stalled next
+		// time round, so it's tried again then.
+		for seq, v := range px.stalled(interval) {
+			px.start(seq, "", v, nil, nil)
+		}
+	}
+}
+
+// the instances this peer has accepted a value for, but
+// which haven't been decided or touched for at least d,
+// with their accepted values.
+func (px *Paxos) stalled(d time.Duration) map[int]interface{} {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	now := px.clock.Now()
+	min := px.minLocked()
+	stalled := map[int]interface{}{}
+	for seq, instance := range px.instances {
+		if seq < min || instance.state != Pending || instance.n_a == "" {
+			continue
+		}
+		last := instance.promisedAt
+		if instance.acceptedAt.After(last) {
+			last = instance.acceptedAt
+		}
+		if now.Sub(last) >= d {
+			stalled[seq] = instance.v_a
+		}
+	}
+	return stalled
+}
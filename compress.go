@@ -0,0 +1,85 @@
+package paxos
+
+//
+// optional gzip of large values on the wire. the sender
+// swaps a value for a packedValue holding its compressed
+// gob encoding; handlers unpack whatever arrives before
+// storing it, so the rest of Paxos never sees one.
+//
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"io/ioutil"
+	"log"
+)
+
+type packedValue struct {
+	Gzip []byte // gzipped gob encoding of the value
+}
+
+func init() {
+	gob.Register(packedValue{})
+}
+
+// v, packed if Config.CompressAbove says it's big enough.
+func (px *Paxos) packValue(v interface{}) interface{} {
+	if px.config.CompressAbove <= 0 || v == nil {
+		return v
+	}
+	if _, ok := v.(packedValue); ok {
+		return v
+	}
+
+	enc, err := encodeValue(v)
+	if err != nil {
+		// let the RPC report it.
+		return v
+	}
+	if len(enc) <= px.config.CompressAbove {
+		return v
+	}
+
+	var zbuf bytes.Buffer
+	zw := gzip.NewWriter(&zbuf)
+	zw.Write(enc)
+	if err := zw.Close(); err != nil {
+		return v
+	}
+	return packedValue{zbuf.Bytes()}
+}
+
+// the gob encoding of v, as it would go over the wire.
+func encodeValue(v interface{}) ([]byte, error) {
+	var enc bytes.Buffer
+	if err := gob.NewEncoder(&enc).Encode(&v); err != nil {
+		return nil, err
+	}
+	return enc.Bytes(), nil
+}
+
+// the value inside v if it's packed, otherwise v.
+func unpackValue(v interface{}) interface{} {
+	p, ok := v.(packedValue)
+	if !ok {
+		return v
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(p.Gzip))
+	if err != nil {
+		log.Printf("paxos: can't unpack value: %v", err)
+		return v
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		log.Printf("paxos: can't unpack value: %v", err)
+		return v
+	}
+	var u interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&u); err != nil {
+		log.Printf("paxos: can't unpack value: %v", err)
+		return v
+	}
+	return u
+}
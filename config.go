@@ -0,0 +1,276 @@
+package paxos
+
+import (
+	"log"
+	"net"
+	"time"
+)
+
+//
+// optional settings for MakeWithConfig(). the zero
+// Config gives the same peer as Make().
+//
+type Config struct {
+	Clock Clock // source of time; the real clock if nil
+
+	// refuse to Start() values whose gob encoding is longer
+	// than this, before sending anything. 0 means no limit.
+	MaxValueBytes int
+
+	// refuse to Start() instances above the live ones once
+	// this many are live, e.g. because Done() is never being
+	// called. 0 means no limit.
+	MaxInstances int
+
+	// run at most this many proposals at once. beyond that,
+	// Start() queues new ones to run as others finish,
+	// StartUnique() refuses them with ErrBusy, and Propose()
+	// waits for one to finish. 0 means no limit.
+	MaxProposers int
+
+	// give up proposing after this many rounds without an
+	// agreement, leaving the instance Pending, rather than
+	// retrying for ever. 0 means no limit.
+	MaxRounds int
+
+	// how long, and for how many keys, StartUnique()
+	// remembers the seq it used for a key. 0 means the
+	// defaults, one minute and 1024 keys.
+	UniqueTTL  time.Duration
+	UniqueKeys int
+
+	// gzip values this peer sends whose gob encoding is
+	// longer than this many bytes. 0 means never. peers
+	// unpack compressed values whatever their own setting.
+	CompressAbove int
+
+	// if set, serve RPCs on this listener, rather than
+	// listening on peers[me]. see MakeWithListener().
+	Listener net.Listener
+
+	// Make() checks that this peer can be reached at
+	// peers[me], as the other peers will try to reach it,
+	// and logs a warning if it can't within a second. with
+	// this set, Make() fails instead. only checked when
+	// Make() serves the RPCs.
+	RequireSelfDial bool
+
+	// if set, a write-ahead log of every instance's promise,
+	// accept and decision, synced before this peer replies,
+	// and replayed when it's restarted with the same WAL,
+	// so a crash can't make it go back on its word. it's
+	// synced whatever Durability says. see wal.go. the
+	// caller opens it with OpenWAL(), and closes it after
+	// Kill().
+	WAL *WAL
+
+	// where to keep state that must survive a restart.
+	// nil means nothing is kept.
+	Persister Persister
+
+	// how Persister is used; see Durability. the zero value
+	// is DurabilitySync. FlushInterval is how often
+	// DurabilityBuffered saves; 0 means every 100ms. the
+	// acceptor's state is the WAL's, which isn't affected.
+	Durability    Durability
+	FlushInterval time.Duration
+
+	// once more than this many instances are in memory,
+	// Done() moves decided ones this peer is done with out
+	// to Persister, though a slow peer keeps Min() below
+	// them, and reads them back when they're asked for.
+	// saves are synchronous, whatever Durability says. 0
+	// means keep everything in memory. see spill.go.
+	SpillAbove int
+
+	// if WaitForPrefix() is still waiting for a gap to be
+	// decided after this long, propose NoOp{} to fill it.
+	// 0 means never propose, just wait.
+	FillGapsAfter time.Duration
+
+	// serve every connection, even after setunreliable(true),
+	// so a production peer can't be made to drop requests
+	// or replies by test-only code.
+	DisableUnreliable bool
+
+	// if a peer misses the Decide for an instance this peer
+	// got agreement on, resend it in the background, first
+	// after DecideRetryInterval and then doubling up to
+	// DecideRetryMax, until it's delivered or the instance
+	// is forgotten. an interval of 0 means don't resend;
+	// a max of 0 means no cap.
+	DecideRetryInterval time.Duration
+	DecideRetryMax      time.Duration
+
+	// where to send RPCs for peer i, looked up on every
+	// call, for peers whose address changes, e.g. when
+	// they're rescheduled. nil means the address passed
+	// to Make(). a peer's own address still comes from
+	// Make(), since that's where it listens.
+	Resolver func(i int) string
+
+	// if set, keep a leader lease of this length in the
+	// background: the leader renews it, and when there's
+	// no leader, the others try to take over. 0 means
+	// leases are only taken by calling AcquireLease().
+	LeaseDuration time.Duration
+
+	// how long peers spread their takeover attempts over
+	// once the leader is gone, so they don't all duel at
+	// once. peer i waits somewhere in the i'th of len(peers)
+	// equal slices of it, so lower peers tend to win.
+	LeaderJitter time.Duration
+
+	// if set, told when this peer hears from the others and
+	// when they don't answer, and asked which it suspects of
+	// being dead, to take over from a dead leader sooner.
+	// SuspectAbove is the suspicion at which a peer counts
+	// as suspected; 0 means 1. see detector.go.
+	FailureDetector FailureDetector
+	SuspectAbove    float64
+
+	// how far this peer's clock may run fast, over a lease,
+	// relative to the peers that granted it. LeaseRead()
+	// stops trusting the lease this long before the leader
+	// thinks it runs out, so that it's still held at every
+	// grantor while reads are served from it.
+	MaxClockDrift time.Duration
+
+	// called before this peer handles each Prepare, Accept,
+	// Decide, PreVote or ExchangeDone, with the method
+	// ("Paxos.Prepare" &c) and its args. returning false
+	// drops the message, as if it had been lost. Query,
+	// Lease, Fetch and StatusRange aren't intercepted. for
+	// auditing, and for tests that need to lose particular
+	// messages.
+	Intercept func(method string, args interface{}) bool
+
+	// if set, this peer refuses Accepts for values it
+	// returns false for, whatever their pnum, so they can't
+	// be decided; a proposer that has a majority refuse
+	// gives up with ErrFiltered. it must be deterministic
+	// and the same on every peer: if peers disagree about a
+	// value, proposals for it can go round forever. it's
+	// called with the peer locked, so mustn't call into it.
+	AcceptFilter func(seq int, v interface{}) bool
+
+	// if set, propose for any instance this peer has
+	// accepted a value for that's gone undecided and
+	// untouched for this long, e.g. because its proposer
+	// died before sending Decide. that re-chooses the
+	// accepted value and tells everyone. 0 means wait for
+	// someone to propose again.
+	CompleteAfter time.Duration
+
+	// if set, called with the new Min() each time it goes
+	// up, from a goroutine of its own, one call at a time.
+	// advances that come while a call runs are reported
+	// together, by one call with the latest Min(). the time
+	// for the application to snapshot and call Done() again.
+	OnMinAdvance func(newMin int)
+
+	// where Append() gets the seqs it tries, instead of one
+	// past the highest this peer knows of. see allocator.go.
+	SeqAllocator SeqAllocator
+
+	// keep the NoOps that fill gaps from the application:
+	// Status() reports an instance decided as NoOp as
+	// Decided with a nil value, and Stream() skips it.
+	// (a Log never shows them either way.)
+	HideNoOps bool
+
+	// if set, send every other peer this peer's view of the
+	// Done()s this often, so Min() advances even when
+	// nothing is being agreed. 0 means Done()s only travel
+	// with agreements.
+	ExchangeDoneInterval time.Duration
+
+	// if set, a proposer asks for a pre-vote before each
+	// Prepare, and peers refuse it while a different
+	// proposer's promise on the instance is younger than
+	// this, so a returning partitioned peer can't preempt
+	// a proposer that's making progress. the cost is that a
+	// proposer that dies mid-round holds its instances up
+	// for this long. 0 means no pre-vote.
+	PreVoteWindow time.Duration
+
+	// gets a span for every propose round, and its phases.
+	// nil means no tracing.
+	Tracer Tracer
+
+	// if set, told of every promise this peer grants, value
+	// it accepts and decision it learns. see audit.go.
+	Audit AuditSink
+
+	// after a round is preempted by a higher pnum, wait a
+	// random time up to this before the next, so duelling
+	// proposers fall out of step. 0 means retry at once.
+	PreemptBackoff time.Duration
+
+	// after a proposer's rounds for an instance have been
+	// preempted this many times in a row, give its next
+	// pnum priority: make it as if its clock were
+	// PriorityBoost ahead, so it outbids the proposers
+	// that keep beating it. 0 means never. see priority.go
+	// for the trade-off.
+	PriorityAfter int
+	PriorityBoost time.Duration
+
+	// seeds this peer's backoff RNG, for reproducible delays
+	// in tests. the peer's index is mixed in, so peers can
+	// share it. 0 means seed from process entropy.
+	BackoffSeed int64
+
+	// propose logs the pnum, value and messages each round
+	// would use, then gives up with ErrDryRun, sending
+	// nothing and changing no state. for teaching and
+	// debugging. the peer still answers other peers.
+	DryRun bool
+
+	// makes the Transport px talks to the other peers over,
+	// instead of net/rpc over unix sockets. the rpcs passed
+	// to MakeWithConfig() is then unused. ReuseConns,
+	// Resolver and DisableUnreliable only apply to the
+	// default transport.
+	Transport func(px *Paxos) Transport
+
+	// check the acceptor's invariants (a decided value never
+	// changes, promises never go down, nothing is accepted
+	// above its promise) on every change, and panic with a
+	// *SafetyViolation if one breaks. off in production.
+	StrictSafety bool
+
+	// whether two values are the same, wherever Paxos
+	// compares them: choosing among accepted values,
+	// StrictSafety, Append() and the consistency checks.
+	// nil means reflect.DeepEqual.
+	ValueEqual func(a, b interface{}) bool
+
+	// keep one connection to each peer and share it between
+	// calls, redialing when it breaks, rather than dialing
+	// for every RPC.
+	ReuseConns bool
+
+	// where to log, e.g. a handler panicking. nil means
+	// the standard logger.
+	Logger *log.Logger
+}
+
+//
+// the time-related calls Paxos makes, so that tests can
+// substitute a clock they control.
+//
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
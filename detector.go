@@ -0,0 +1,130 @@
+package paxos
+
+//
+// failure detection, for faster leader takeover.
+//
+// with Config.FailureDetector set, a peer tells the detector
+// each time it hears from another peer (an RPC to it that
+// got a reply, or a lease renewal from it) and each time
+// an RPC to it fails, and asks it how much it suspects a
+// peer is dead. keepLeader() uses that two ways: a follower
+// that suspects the leader stops polling and waits for its
+// grant to run out instead, and then a peer that suspects
+// every lower-numbered peer as well, so is next in line, takes
+// over at once, without waiting out its LeaderJitter slot.
+// no one can take over sooner than that: the dead leader's
+// lease still holds at its grantors, so that a leader that's
+// merely cut off can't be contradicted while it still thinks
+// it leads.
+//
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type FailureDetector interface {
+	// an RPC to peer got a reply, or peer sent us one.
+	Heard(peer int, at time.Time)
+	// an RPC to peer went unanswered. a detector that goes
+	// by silences alone may ignore it, as PhiDetector does.
+	Missed(peer int, at time.Time)
+	// how strongly peer is suspected to be dead at now;
+	// 0 is not at all. compared to Config.SuspectAbove.
+	Suspicion(peer int, now time.Time) float64
+}
+
+// what Config.SuspectAbove means when it's 0.
+const defaultSuspectAbove = 1.0
+
+//
+// a phi-accrual failure detector: it learns how often it
+// usually hears from each peer, and its suspicion is phi,
+// -log10 of the chance that a peer that's still alive
+// would have been silent as long, modelling the gaps as
+// exponential. so phi 1 means a 10% chance of being wrong,
+// phi 2 a 1% chance, and so on. a peer it's heard from
+// fewer than twice isn't suspected at all. missed RPCs
+// don't count: a silence is evidence enough.
+//
+type PhiDetector struct {
+	mu   sync.Mutex
+	last map[int]time.Time
+	mean map[int]time.Duration // moving average of the gaps
+}
+
+func NewPhiDetector() *PhiDetector {
+	return &PhiDetector{last: map[int]time.Time{}, mean: map[int]time.Duration{}}
+}
+
+func (d *PhiDetector) Heard(peer int, at time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if last, ok := d.last[peer]; ok && at.After(last) {
+		gap := at.Sub(last)
+		if mean, ok := d.mean[peer]; ok {
+			d.mean[peer] = (7*mean + gap) / 8
+		} else {
+			d.mean[peer] = gap
+		}
+	}
+	if at.After(d.last[peer]) {
+		d.last[peer] = at
+	}
+}
+
+// a no-op: the silence that follows is what raises phi.
+func (d *PhiDetector) Missed(peer int, at time.Time) {}
+
+func (d *PhiDetector) Suspicion(peer int, now time.Time) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	mean, ok := d.mean[peer]
+	if !ok || mean <= 0 || !now.After(d.last[peer]) {
+		return 0
+	}
+	return float64(now.Sub(d.last[peer])) / float64(mean) * math.Log10E
+}
+
+// tell Config.FailureDetector how an RPC to peer went.
+// it's the application's code, so don't hold px.mu.
+func (px *Paxos) detect(peer int, replied bool) {
+	fd := px.config.FailureDetector
+	if fd == nil || peer == px.me {
+		return
+	}
+	if replied {
+		fd.Heard(peer, px.clock.Now())
+	} else {
+		fd.Missed(peer, px.clock.Now())
+	}
+}
+
+// does Config.FailureDetector suspect peer is dead?
+func (px *Paxos) suspects(peer int) bool {
+	fd := px.config.FailureDetector
+	if fd == nil || peer == px.me {
+		return false
+	}
+	threshold := px.config.SuspectAbove
+	if threshold <= 0 {
+		threshold = defaultSuspectAbove
+	}
+	return fd.Suspicion(peer, px.clock.Now()) >= threshold
+}
+
+// should this peer take over without waiting out its jitter
+// slot? yes if there's a FailureDetector, and it suspects
+// every lower-numbered peer, which would otherwise go first.
+func (px *Paxos) firstUnsuspected() bool {
+	if px.config.FailureDetector == nil {
+		return false
+	}
+	for i := 0; i < px.me; i++ {
+		if !px.suspects(i) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package paxos
+
+//
+// Config.DryRun: propose goes through the motions of a
+// round, logging what it would send, but sends nothing and
+// changes nothing, for watching the algorithm's choices on
+// a given input.
+//
+
+// what one propose round would do for seq with v, logged
+// rather than done. this peer's own accepted value, if any,
+// stands in for the Prepare replies it would have gathered.
+func (px *Paxos) dryPropose(seq int, v interface{}, fixed string) error {
+	pnum := fixed
+	if pnum == "" {
+		var err error
+		if pnum, err = px.generatePNum(); err != nil {
+			return err
+		}
+	}
+	px.logf("Paxos(%v) dry run: seq %v: pnum %v", px.me, seq, pnum)
+	px.logf("Paxos(%v) dry run: seq %v: would send Prepare(%v) to %v peers",
+		px.me, seq, pnum, len(px.peers))
+
+	replies := []PrepareReply{}
+	px.mu.Lock()
+	if instance, ok := px.lookupLocked(seq); ok && instance.n_a != "" {
+		replies = append(replies, PrepareReply{Err: OK, AcceptPnum: instance.n_a,
+			AcceptValue: instance.v_a, HasAccepted: true})
+	}
+	px.mu.Unlock()
+	chosen, err := selectValue(v, replies, px.valueEqual)
+	if len(replies) == 0 {
+		px.logf("Paxos(%v) dry run: seq %v: nothing accepted here, would choose own value %v",
+			px.me, seq, chosen)
+	} else {
+		px.logf("Paxos(%v) dry run: seq %v: accepted here at %v, would choose %v over own value %v",
+			px.me, seq, replies[0].AcceptPnum, chosen, v)
+	}
+	if err != nil {
+		px.logf("Paxos(%v) dry run: seq %v: %v", px.me, seq, err)
+	}
+
+	px.logf("Paxos(%v) dry run: seq %v: would send Accept(%v, %v) to %v peers",
+		px.me, seq, pnum, chosen, len(px.peers))
+	px.logf("Paxos(%v) dry run: seq %v: would send Decide(%v, %v) to %v peers on a majority",
+		px.me, seq, pnum, chosen, len(px.peers))
+	return ErrDryRun
+}
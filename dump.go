@@ -0,0 +1,335 @@
+package paxos
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+type dumpState struct {
+	Me        int            `json:"me"`
+	Peers     []string       `json:"peers"`
+	Dones     []int          `json:"dones"`
+	Min       int            `json:"min"`
+	Max       int            `json:"max"`
+	Instances []dumpInstance `json:"instances"`
+}
+
+type dumpInstance struct {
+	Seq   int    `json:"seq"`
+	State string `json:"state"`
+	NP    string `json:"n_p"`
+	NA    string `json:"n_a"`
+	Value string `json:"value,omitempty"` // %v of the accepted value
+}
+
+//
+// a JSON snapshot of this peer's whole view, for comparing
+// peers when something has gone wrong. values are included
+// only as their %v formatting.
+//
+func (px *Paxos) DumpState() []byte {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	d := dumpState{
+		Me:        px.me,
+		Peers:     append([]string{}, px.peers...),
+		Dones:     append([]int{}, px.dones...),
+		Min:       px.minLocked(),
+		Max:       px.maxSeen,
+		Instances: []dumpInstance{},
+	}
+	seqs := make([]int, 0, len(px.instances)+len(px.spilled))
+	for seq := range px.instances {
+		seqs = append(seqs, seq)
+	}
+	for seq := range px.spilled {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+	for _, seq := range seqs {
+		instance, ok := px.lookupLocked(seq)
+		if !ok {
+			continue
+		}
+		di := dumpInstance{Seq: seq, State: instance.state.String(), NP: instance.n_p, NA: instance.n_a}
+		if instance.v_a != nil {
+			di.Value = fmt.Sprint(instance.v_a)
+		}
+		d.Instances = append(d.Instances, di)
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		// can't happen: everything in d is a string or int.
+		panic(err)
+	}
+	return data
+}
+
+//
+// counts describing a peer, all taken at one instant.
+//
+type StateSnapshot struct {
+	Min       int // as Min()
+	Max       int // as Max()
+	Instances int // live instances: Decided + Pending
+	Decided   int
+	Pending   int
+}
+
+//
+// Min, Max and instance counts, taken under one lock so
+// they agree with each other, unlike separate calls.
+//
+func (px *Paxos) Snapshot() StateSnapshot {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	s := StateSnapshot{Min: px.minLocked(), Max: px.maxSeen}
+	for _, instance := range px.instances {
+		if instance.state == Decided {
+			s.Decided++
+		} else {
+			s.Pending++
+		}
+	}
+	s.Decided += len(px.spilled)
+	s.Instances = s.Decided + s.Pending
+	return s
+}
+
+//
+// the seqs of this peer's live instances that aren't
+// decided yet, lowest first.
+//
+func (px *Paxos) PendingSeqs() []int {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	min := px.minLocked()
+	seqs := []int{}
+	for seq, instance := range px.instances {
+		if seq >= min && instance.state == Pending {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Ints(seqs)
+	return seqs
+}
+
+//
+// the pnum and value this peer last accepted for seq, even
+// if it isn't decided; for comparing across peers which
+// value a Pending instance is heading for. ok is false if
+// this peer hasn't accepted anything for seq, or has
+// forgotten it. read-only: it changes no promises.
+//
+func (px *Paxos) AcceptedValue(seq int) (pnum string, v interface{}, ok bool) {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	if seq < px.minLocked() {
+		return "", nil, false
+	}
+	instance, exist := px.lookupLocked(seq)
+	if !exist || instance.n_a == "" {
+		return "", nil, false
+	}
+	return instance.n_a, instance.v_a, true
+}
+
+//
+// the highest pnum this peer has promised for seq, which
+// shows how far dueling proposers have driven it up. ok is
+// false if this peer hasn't promised anything for seq, or
+// has forgotten it. read-only, like AcceptedValue().
+//
+func (px *Paxos) PromisedPNum(seq int) (string, bool) {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	if seq < px.minLocked() {
+		return "", false
+	}
+	instance, exist := px.lookupLocked(seq)
+	if !exist || instance.n_p == "" {
+		return "", false
+	}
+	return instance.n_p, true
+}
+
+//
+// the value decided for seq and the pnum of the proposal
+// that decided it, read together under one lock, so that a
+// consumer replicating the log can order and dedup entries
+// without racing a separate Status() and AcceptedValue().
+// ok is false unless this peer knows seq is Decided.
+//
+func (px *Paxos) DecidedEntry(seq int) (v interface{}, pnum string, ok bool) {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	if seq < px.minLocked() {
+		return nil, "", false
+	}
+	instance, exist := px.lookupLocked(seq)
+	if !exist || instance.state != Decided {
+		return nil, "", false
+	}
+	return instance.v_a, instance.n_a, true
+}
+
+//
+// the fraction of the seqs from from to to, inclusive, that
+// this peer knows to be decided, for a progress bar over a
+// bulk load or catch-up. forgotten seqs count as decided,
+// having been decided before they were forgotten. an empty
+// range is all done.
+//
+func (px *Paxos) RangeProgress(from, to int) float64 {
+	if to < from {
+		return 1
+	}
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	// count the forgotten seqs, then look at the live
+	// instances, rather than at every seq in the range: it
+	// may be huge, and handlers wait while we hold px.mu.
+	min := px.minLocked()
+	done := 0
+	if min > from {
+		done = minInt(min-1, to) - from + 1
+	}
+	hi := minInt(to, px.maxSeen)
+	for seq, instance := range px.instances {
+		if seq >= from && seq >= min && seq <= hi && instance.state == Decided {
+			done++
+		}
+	}
+	for seq := range px.spilled {
+		if seq >= from && seq >= min && seq <= hi {
+			done++ // only decided instances are spilled
+		}
+	}
+	return float64(done) / float64(to-from+1)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+//
+// how many of the instances this peer has learned were
+// decided by each peer's proposal, for spotting a proposer
+// that always wins or one that never does. the winner is
+// read from the deciding pnum rather than from who sent
+// the Decide, since a Decide can be passed on by a peer
+// that only learned it. forgotten instances still count.
+//
+func (px *Paxos) Proposers() map[int]int {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	wins := make(map[int]int, len(px.wins))
+	for who, n := range px.wins {
+		wins[who] = n
+	}
+	return wins
+}
+
+//
+// how many rounds this peer's proposers took over seq, all
+// told, once they've finished: 1 for an uncontended
+// agreement, more when proposers dueled or messages were
+// lost, so a rise in it is an early warning. 0 if this
+// peer didn't propose for seq, or has forgotten it.
+//
+func (px *Paxos) Rounds(seq int) int {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	if instance, ok := px.lookupLocked(seq); ok {
+		return instance.rounds
+	}
+	return 0
+}
+
+// count n more rounds spent proposing for seq.
+func (px *Paxos) addRounds(seq int, n int) {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	if instance, ok := px.instances[seq]; ok {
+		instance.rounds += n
+	}
+}
+
+// the most seqs one StatusRange RPC reports on.
+const maxStatusRange = 1000
+
+type StatusRangeArgs struct {
+	From    int
+	To      int  // inclusive
+	Values  bool // include decided values
+	GroupID int
+}
+
+type StatusRangeReply struct {
+	Err    string
+	To     int                 // last seq covered; less than asked if capped
+	Fates  map[int]Fate        // every seq in [From, To], as Status() would say
+	Values map[int]interface{} // decided seqs' values, if asked for
+}
+
+//
+// Status() for a range of seqs in one RPC, for monitors.
+// covers at most maxStatusRange seqs; if reply.To is less
+// than args.To, ask again from reply.To+1.
+//
+func (px *Paxos) StatusRange(args *StatusRangeArgs, reply *StatusRangeReply) (err error) {
+	defer px.recoverHandler("Paxos.StatusRange", &err)
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	reply.Err = OK
+	reply.To = args.To
+	reply.Fates = map[int]Fate{}
+	if args.Values {
+		reply.Values = map[int]interface{}{}
+	}
+	if args.To < args.From {
+		return nil
+	}
+	// To-From can't overflow unless From is negative, when
+	// it wraps below zero.
+	if d := args.To - args.From; d < 0 || d >= maxStatusRange {
+		reply.To = args.From + maxStatusRange - 1
+	}
+
+	min := px.minLocked()
+	// stop at To rather than past it, which could wrap.
+	for seq := args.From; ; seq++ {
+		instance, ok := px.lookupLocked(seq)
+		switch {
+		case seq < min:
+			reply.Fates[seq] = Forgotten
+		case !ok:
+			reply.Fates[seq] = Pending
+		default:
+			reply.Fates[seq] = instance.state
+			if args.Values && instance.state == Decided {
+				reply.Values[seq] = instance.v_a
+			}
+		}
+		if seq == reply.To {
+			break
+		}
+	}
+	return nil
+}
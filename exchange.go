@@ -0,0 +1,141 @@
+package paxos
+
+//
+// gossiping Done()s.
+//
+// peers normally learn each other's Done()s from the
+// messages of agreements in progress, so when nothing is
+// being agreed Min() never moves, however many Done()s are
+// called. with Config.ExchangeDoneInterval set, each peer
+// also sends its view of everyone's Done() to the others
+// on a ticker, and takes theirs in reply.
+//
+
+import (
+	"context"
+	"time"
+)
+
+type ExchangeDoneArgs struct {
+	Me      int
+	Dones   []int // the sender's view of every peer's Done()
+	GroupID int
+}
+
+type ExchangeDoneReply struct {
+	Err   string
+	Dones []int // the receiver's view, after taking the sender's
+}
+
+// take the sender's view of the Done()s, and reply with ours.
+func (px *Paxos) ExchangeDone(args *ExchangeDoneArgs, reply *ExchangeDoneReply) (err error) {
+	defer px.recoverHandler("Paxos.ExchangeDone", &err)
+	if !px.intercept("Paxos.ExchangeDone", args) {
+		return ErrDropped
+	}
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	px.mergeDonesLocked(args.Dones)
+	reply.Err = OK
+	reply.Dones = append([]int(nil), px.dones...)
+	return nil
+}
+
+// raise our view of the other peers' Done()s to dones, and
+// forget whatever that lets us. Done()s only go up, so a
+// second-hand or stale view is safe to take. our own Done()
+// is ours to say. caller must hold px.mu.
+func (px *Paxos) mergeDonesLocked(dones []int) {
+	for i, d := range dones {
+		if i < len(px.dones) && i != px.me && d > px.dones[i] {
+			px.dones[i] = d
+		}
+	}
+	px.minLocked()
+}
+
+// exchange Done()s with every other peer each interval,
+// until killed.
+func (px *Paxos) exchangeDones(interval time.Duration) {
+	for px.isdead() == false {
+		<-px.clock.After(interval)
+		for i := range px.peers {
+			if i == px.me || px.isdead() {
+				continue
+			}
+			px.mu.Lock()
+			args := ExchangeDoneArgs{Me: px.me, Dones: append([]int(nil), px.dones...), GroupID: px.group}
+			px.mu.Unlock()
+			var reply ExchangeDoneReply
+			if px.invoke(i, "Paxos.ExchangeDone", &args, &reply) && reply.Err == OK {
+				px.mu.Lock()
+				px.mergeDonesLocked(reply.Dones)
+				px.mu.Unlock()
+			}
+		}
+	}
+}
+
+//
+// the Min() every peer could agree on now: one more than
+// the lowest Done() over all the peers, as each reachable
+// one reports its own, rather than from this peer's
+// possibly stale view. for a peer that can't be reached,
+// the highest Done() anyone has heard from it is used,
+// which may be below its real one, so the answer is never
+// above the true Min(). returns ctx's error if ctx is done
+// before every peer has answered or failed to.
+//
+func (px *Paxos) ClusterMin(ctx context.Context) (int, error) {
+	// sending no Dones makes ExchangeDone a plain read.
+	args := ExchangeDoneArgs{Me: px.me, GroupID: px.group}
+	type answer struct {
+		i     int
+		dones []int // nil if peer i couldn't be reached
+	}
+	answers := make(chan answer, len(px.peers))
+	for i := range px.peers {
+		go func(i int) {
+			var reply ExchangeDoneReply
+			if !px.invoke(i, "Paxos.ExchangeDone", &args, &reply) || reply.Err != OK {
+				reply.Dones = nil
+			}
+			answers <- answer{i, reply.Dones}
+		}(i)
+	}
+
+	own := make([]int, len(px.peers)) // each peer's Done(), from itself
+	heard := px.DoneVector()          // the best second-hand view
+	reached := make([]bool, len(px.peers))
+	for n := 0; n < len(px.peers); n++ {
+		var a answer
+		select {
+		case a = <-answers:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		if a.dones == nil || len(a.dones) != len(px.peers) {
+			continue
+		}
+		reached[a.i] = true
+		own[a.i] = a.dones[a.i]
+		for j, d := range a.dones {
+			if d > heard[j] {
+				heard[j] = d
+			}
+		}
+	}
+
+	min := -1
+	for i := range px.peers {
+		d := own[i]
+		if !reached[i] {
+			d = heard[i]
+		}
+		if i == 0 || d < min {
+			min = d
+		}
+	}
+	return min + 1, nil
+}
@@ -0,0 +1,95 @@
+package paxos
+
+//
+// per-peer, per-method RPC latency, to help find a slow
+// peer. each series is a histogram with power-of-two
+// buckets, so memory use is fixed however many RPCs are
+// made, and quantiles are accurate to within a factor of
+// two.
+//
+
+import "fmt"
+import "time"
+
+const (
+	latencyBase    = 10 * time.Microsecond // upper bound of the first bucket
+	latencyBuckets = 24                    // the last bucket is ~80s and up
+)
+
+type latencyHist struct {
+	count   int64
+	sum     time.Duration
+	buckets [latencyBuckets]int64
+}
+
+//
+// a summary of one peer's latency for one RPC method.
+//
+type LatencyStats struct {
+	Count int64
+	Mean  time.Duration
+	P99   time.Duration // an upper bound, to within a factor of two
+}
+
+func (h *latencyHist) add(d time.Duration) {
+	h.count++
+	h.sum += d
+	b := 0
+	for bound := latencyBase; d >= bound && b < latencyBuckets-1; bound *= 2 {
+		b++
+	}
+	h.buckets[b]++
+}
+
+// the upper bound of the bucket holding the q'th quantile.
+func (h *latencyHist) quantile(q float64) time.Duration {
+	rank := int64(q * float64(h.count))
+	if rank >= h.count {
+		rank = h.count - 1
+	}
+	seen := int64(0)
+	bound := latencyBase
+	for b := 0; b < latencyBuckets-1; b++ {
+		seen += h.buckets[b]
+		if seen > rank {
+			return bound
+		}
+		bound *= 2
+	}
+	return bound
+}
+
+func (px *Paxos) recordLatency(peer int, method string, d time.Duration) {
+	px.latMu.Lock()
+	defer px.latMu.Unlock()
+
+	if px.latencies == nil {
+		px.latencies = map[string]*latencyHist{}
+	}
+	key := fmt.Sprintf("%d/%s", peer, method)
+	h, ok := px.latencies[key]
+	if !ok {
+		h = &latencyHist{}
+		px.latencies[key] = h
+	}
+	h.add(d)
+}
+
+//
+// latency of the RPCs this peer has sent that got replies,
+// keyed by "<peer index>/<method>", e.g. "2/Paxos.Accept".
+//
+func (px *Paxos) Latencies() map[string]LatencyStats {
+	px.latMu.Lock()
+	defer px.latMu.Unlock()
+
+	stats := map[string]LatencyStats{}
+	for key, h := range px.latencies {
+		stats[key] = LatencyStats{
+			Count: h.count,
+			Mean:  h.sum / time.Duration(h.count),
+			P99:   h.quantile(0.99),
+		}
+	}
+	return stats
+}
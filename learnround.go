@@ -0,0 +1,97 @@
+package paxos
+
+//
+// learning an instance without proposing for it.
+//
+// filling a gap with NoOp{} decides the NoOp if nothing else
+// was accepted. a caller that only wants whatever value is
+// already on its way to being chosen uses Learn() instead:
+// it runs the Prepare phase like a proposer, and if the
+// promises carry an accepted value, finishes that value's
+// Accept and Decide, as any proposer would have to. if
+// none of them has accepted anything, Learn() gives up
+// with ErrNothingAccepted rather than impose a value of
+// its own.
+//
+
+import (
+	"context"
+	"time"
+)
+
+//
+// learn the value of instance seq: the decided value, if
+// this peer knows it, or else the value that a majority's
+// promises say was accepted, which Learn() then gets
+// decided. returns ErrNothingAccepted if a majority
+// promised and none of them had accepted a value,
+// ErrForgotten if seq is older than Min(), ErrFiltered if
+// the value was refused by Config.AcceptFilter,
+// ErrNoMajority if this peer is killed before it learns
+// seq, ErrPNumExhausted if this peer has run out of pnums,
+// or the context's error if ctx is done first.
+//
+func (px *Paxos) Learn(ctx context.Context, seq int) (interface{}, error) {
+	var delay time.Duration
+	for !px.isdead() {
+		switch fate, v := px.rawStatus(seq); fate {
+		case Decided:
+			return v, nil
+		case Forgotten:
+			return nil, ErrForgotten
+		}
+
+		pnum, err := px.generatePNum()
+		if err != nil {
+			return nil, err
+		}
+		args := PrepareArgs{Seq: seq, PNum: pnum, GroupID: px.group}
+		replies := make([]PrepareReply, len(px.peers))
+		granted, accepted := 0, false
+		for i := range px.peers {
+			reply := PrepareReply{Err: Reject}
+			px.invoke(i, "Paxos.Prepare", &args, &reply)
+			if reply.Err == OK {
+				granted++
+				accepted = accepted || reply.HasAccepted
+			}
+			reply.AcceptValue = unpackValue(reply.AcceptValue)
+			replies[i] = reply
+		}
+
+		if granted >= px.majority() {
+			if !accepted {
+				return nil, ErrNothingAccepted
+			}
+			v, err := selectValue(nil, replies, px.valueEqual)
+			if err != nil {
+				px.logf("Paxos(%v) seq %v pnum %v: %v", px.me, seq, pnum, err)
+			}
+			value := px.packValue(v)
+			ok, _, decided, filtered := px.sendAccept(seq, pnum, value, noopSpan{})
+			if filtered {
+				return nil, ErrFiltered
+			}
+			if decided {
+				px.sendDecided(seq)
+				continue
+			}
+			if ok {
+				px.sendDecide(seq, pnum, value)
+				continue
+			}
+		}
+
+		if delay == 0 {
+			delay = 5 * time.Millisecond
+		} else if delay *= 2; delay > time.Second {
+			delay = time.Second
+		}
+		select {
+		case <-px.clock.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, ErrNoMajority
+}
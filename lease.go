@@ -0,0 +1,212 @@
+package paxos
+
+//
+// Leader leases.
+//
+// a peer becomes leader by asking every peer for a lease of
+// some duration. a peer grants it unless it has already
+// granted an unexpired lease to someone else, and while its
+// grant lasts it rejects Prepares and Accepts from every
+// other proposer. so once a majority has granted, the
+// leader's proposals cover the next instances without
+// competition until the lease runs out, and nothing past
+// what the grantors had seen is decided but by the leader,
+// which is what LeaseRead() relies on.
+//
+// the leader counts its lease from just before it asked,
+// and the grantors from when they were asked, so the leader
+// always believes its lease has expired first.
+//
+
+import (
+	"time"
+)
+
+type LeaseArgs struct {
+	Leader   int           // the peer asking for the lease
+	Duration time.Duration // how long it wants it for
+	GroupID  int
+}
+
+type LeaseReply struct {
+	Err    string
+	Holder int // who holds the lease, if Reject
+	Max    int // the highest seq the grantor has seen, if OK
+}
+
+func (px *Paxos) Lease(args *LeaseArgs, reply *LeaseReply) (err error) {
+	defer px.recoverHandler("Paxos.Lease", &err)
+	defer px.detect(args.Leader, true) // after unlocking
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	now := px.clock.Now()
+	if px.leaseHolder != -1 && px.leaseHolder != args.Leader && now.Before(px.leaseExpiry) {
+		reply.Err = Reject
+		reply.Holder = px.leaseHolder
+		return nil
+	}
+	px.leaseHolder = args.Leader
+	px.leaseExpiry = now.Add(args.Duration)
+	reply.Err = OK
+	reply.Holder = args.Leader
+	reply.Max = px.maxSeen
+	return nil
+}
+
+//
+// ask the peers to make this peer leader for d. returns
+// true if a majority agreed, in which case IsLeader() is
+// true until the lease runs out. call it again before then
+// to renew.
+//
+func (px *Paxos) AcquireLease(d time.Duration) bool {
+	start := px.clock.Now()
+	args := LeaseArgs{Leader: px.me, Duration: d, GroupID: px.group}
+	granted := 0
+	max := -1
+	for i := range px.peers {
+		var reply LeaseReply
+		if px.invoke(i, "Paxos.Lease", &args, &reply) && reply.Err == OK {
+			granted++
+			if reply.Max > max {
+				max = reply.Max
+			}
+		}
+	}
+	if granted < px.majority() {
+		return false
+	}
+
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	px.leaseUntil = start.Add(d)
+	// anything decided before the grants was accepted by a
+	// majority, so by at least one grantor, and is within
+	// what they had seen.
+	if max > px.leaseBarrier {
+		px.leaseBarrier = max
+	}
+	return true
+}
+
+//
+// this peer's view of the current leader: itself if it
+// holds a lease granted by a majority, else the peer it
+// has granted an unexpired lease to, else -1 if there is
+// no leader or it's contested. the view is local and may
+// be stale; another peer may have become leader since.
+//
+func (px *Paxos) Leader() int {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	return px.leaderLocked()
+}
+
+func (px *Paxos) leaderLocked() int {
+	now := px.clock.Now()
+	if now.Before(px.leaseUntil) {
+		return px.me
+	}
+	if px.leaseHolder != -1 && px.leaseHolder != px.me && now.Before(px.leaseExpiry) {
+		return px.leaseHolder
+	}
+	return -1
+}
+
+//
+// read instance seq from this peer's own state, without
+// asking the others, if it holds an unexpired lease. ok is
+// true, and the fate is the agreed one, if seq is Decided
+// here, or if it's past everything the grantors had seen
+// when they granted the lease and past every seq this peer
+// has sent an Accept for: the grantors refuse every other
+// proposer while the lease lasts, so nothing there can have
+// been decided yet, and the fate is Pending. ok is false on
+// followers, once the lease is within Config.MaxClockDrift
+// of running out, and for a seq that may have been decided
+// without this peer learning of it yet; the caller should
+// then fall back to Status() after a round of agreement.
+//
+func (px *Paxos) LeaseRead(seq int) (Fate, interface{}, bool) {
+	px.mu.Lock()
+	held := px.clock.Now().Add(px.config.MaxClockDrift).Before(px.leaseUntil)
+	barrier := px.leaseBarrier
+	px.mu.Unlock()
+
+	if !held {
+		return Pending, nil, false
+	}
+	fate, v := px.Status(seq)
+	if fate == Decided || (fate == Pending && seq > barrier) {
+		return fate, v, true
+	}
+	return fate, nil, false
+}
+
+// has this peer granted an unexpired lease to another
+// peer? its own proposals would then only be refused.
+func (px *Paxos) following() bool {
+	leader := px.Leader()
+	return leader != -1 && leader != px.me
+}
+
+//
+// does this peer believe it is the leader? see Leader().
+//
+func (px *Paxos) IsLeader() bool {
+	return px.Leader() == px.me
+}
+
+// has this peer leased leadership to some proposer
+// other than the one that issued pnum?
+// caller must hold px.mu.
+func (px *Paxos) leasedAwayLocked(pnum string) bool {
+	if px.leaseHolder == -1 || !px.clock.Now().Before(px.leaseExpiry) {
+		return false
+	}
+	_, proposer, ok := splitPNum(pnum)
+	return !ok || proposer != px.leaseHolder
+}
+
+//
+// run in the background if Config.LeaseDuration is set:
+// renew the lease while leader, and when there is no
+// leader, wait out this peer's jitter slot and then try
+// to become leader, unless someone beat us to it. with a
+// Config.FailureDetector, a suspected leader is waited out
+// to the end of its grant, and the takeover skips the
+// jitter if this peer is next in line; see detector.go.
+//
+func (px *Paxos) keepLeader() {
+	d := px.config.LeaseDuration
+	for px.isdead() == false {
+		wait := d / 3
+		if leader := px.Leader(); leader == px.me {
+			px.AcquireLease(d)
+		} else if leader == -1 {
+			if !px.firstUnsuspected() {
+				<-px.clock.After(px.leaderJitter())
+			}
+			if px.isdead() == false && px.Leader() == -1 {
+				px.AcquireLease(d)
+			}
+		} else if px.suspects(leader) {
+			// nothing to do till our grant runs out.
+			px.mu.Lock()
+			wait = px.leaseExpiry.Sub(px.clock.Now())
+			px.mu.Unlock()
+		}
+		<-px.clock.After(wait)
+	}
+}
+
+// a random wait within this peer's slice of LeaderJitter.
+func (px *Paxos) leaderJitter() time.Duration {
+	slot := px.config.LeaderJitter / time.Duration(len(px.peers))
+	if slot <= 0 {
+		return 0
+	}
+	return time.Duration(px.me)*slot + px.randDuration(slot)
+}
@@ -0,0 +1,169 @@
+package paxos
+
+//
+// a replicated log on top of Paxos: Append() puts a value
+// in the next free instance, and ReadFrom() hands back the
+// decided values in order, calling Done() behind them.
+//
+
+import (
+	"context"
+	"encoding/gob"
+	"sync"
+)
+
+// one decided log entry.
+type Entry struct {
+	Seq   int
+	Value interface{}
+	Err   error // if set, there's no Value and nothing follows
+}
+
+// what Log proposes, so Append() can tell its own value
+// from an equal one another appender got in first.
+type logValue struct {
+	ID    string
+	Value interface{}
+}
+
+func init() {
+	gob.Register(logValue{})
+}
+
+// how far Stream() reads ahead of a slow consumer.
+const streamBuffer = 16
+
+//
+// the decided values from seq from on, in order, each sent
+// once it and everything before it has been decided here.
+// the channel holds at most streamBuffer entries, so a slow
+// consumer holds back reading rather than piling them up.
+// it's closed when ctx is done, or after an entry with
+// Err set, e.g. ErrForgotten if the consumer fell behind
+// Min(). with Config.HideNoOps, instances decided as NoOp
+// are skipped.
+//
+func (px *Paxos) Stream(ctx context.Context, from int) <-chan Entry {
+	ch := make(chan Entry, streamBuffer)
+	go func() {
+		defer close(ch)
+		for seq := from; ; seq++ {
+			e := Entry{Seq: seq}
+			e.Value, e.Err = px.waitDecided(ctx, seq, nil)
+			if ctx.Err() != nil {
+				return
+			}
+			if e.Err == nil && px.config.HideNoOps && isNoOp(e.Value) {
+				continue
+			}
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+				return
+			}
+			if e.Err != nil {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+type Log struct {
+	px     *Paxos
+	ctx    context.Context // cancelled by Close()
+	cancel context.CancelFunc
+
+	mu   sync.Mutex
+	next int // no point trying to Append() below this
+}
+
+//
+// a Log using px. every peer should use a Log, and nothing
+// else, to propose in px's instances.
+//
+func NewLog(px *Paxos) *Log {
+	l := &Log{px: px}
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	return l
+}
+
+// stop the readers started by ReadFrom().
+func (l *Log) Close() {
+	l.cancel()
+}
+
+//
+// add v to the end of the log, returning the seq it went
+// in at. if another appender wins an instance, Append()
+// moves on to the next one.
+//
+func (l *Log) Append(ctx context.Context, v interface{}) (int, error) {
+	id, err := l.px.generatePNum()
+	if err != nil {
+		return -1, err
+	}
+	lv := logValue{ID: id, Value: v}
+	for {
+		seq := l.px.FirstGap()
+		l.mu.Lock()
+		if seq < l.next {
+			seq = l.next
+		}
+		l.mu.Unlock()
+
+		decided, err := l.px.Propose(ctx, seq, lv)
+		if err == ErrForgotten {
+			continue
+		}
+		if err != nil {
+			return -1, err
+		}
+
+		l.mu.Lock()
+		if seq >= l.next {
+			l.next = seq + 1
+		}
+		l.mu.Unlock()
+		if dv, ok := decided.(logValue); ok && dv.ID == lv.ID {
+			return seq, nil
+		}
+	}
+}
+
+//
+// the log's entries in order, starting at seq. each entry
+// is Done() once it's been received, so the log compacts
+// behind the reader. the channel is closed by Close(), or
+// if the reader falls behind Min(). NoOps that filled gaps
+// are always skipped, and Config.FillGapsAfter applies to gaps
+// the reader is waiting on.
+//
+func (l *Log) ReadFrom(seq int) <-chan Entry {
+	ch := make(chan Entry)
+	go func() {
+		defer close(ch)
+		for ; ; seq++ {
+			if err := l.px.WaitForPrefix(l.ctx, seq); err != nil {
+				return
+			}
+			fate, v := l.px.rawStatus(seq)
+			if fate != Decided {
+				l.px.logf("Paxos(%v) log reader at %v: %v", l.px.me, seq, fate)
+				return
+			}
+			if !isNoOp(v) {
+				if lv, ok := v.(logValue); ok {
+					v = lv.Value
+				}
+				select {
+				case ch <- Entry{Seq: seq, Value: v}:
+				case <-l.ctx.Done():
+					return
+				}
+			}
+			l.px.Done(seq)
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,179 @@
+package paxos
+
+//
+// an in-process Transport, for tests and simulations that
+// run every peer in one process.
+//
+// peers made with the same MemNetwork's Transport as their
+// Config.Transport reach each other through it rather than
+// through sockets, which is faster and doesn't depend on
+// the file system. calls still go through Handle() to the
+// real handlers, each in a goroutine of its own as net/rpc
+// would run it, and args and replies are copied through gob
+// on the way, so that caller and handler share no memory
+// and whatever wouldn't fit through net/rpc fails here too.
+//
+// a MemNetwork can delay every call, and lose some: a lost
+// call is dropped either on the way there, before the
+// handler runs, or on the way back, after it has, like the
+// unreliable mode of the default transport. losses are
+// drawn from the network's own RNG, so that a run can be
+// repeated with Seed().
+//
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math/rand"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//
+// the router shared by the peers of one in-process group.
+// the zero value is ready to use, with no delay and no loss.
+//
+type MemNetwork struct {
+	dropped int64 // calls lost, for testing; first, for 64-bit atomic alignment
+	mu      sync.Mutex
+	peers   map[string]*Paxos // address -> the peer listening there
+	latency time.Duration
+	loss    float64
+	rng     *rand.Rand
+}
+
+//
+// make each call take d longer, from when it's sent to
+// when the handler runs.
+//
+func (n *MemNetwork) SetLatency(d time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.latency = d
+}
+
+//
+// lose each call with probability p, from 0 (none) to 1
+// (every one).
+//
+func (n *MemNetwork) SetLoss(p float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.loss = p
+}
+
+//
+// seed the RNG that picks which calls are lost. without
+// it, every MemNetwork starts from the same seed.
+//
+func (n *MemNetwork) Seed(seed int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.rng = rand.New(rand.NewSource(seed))
+}
+
+//
+// a Transport for px over this network, for Config.Transport.
+//
+func (n *MemNetwork) Transport(px *Paxos) Transport {
+	return &MemTransport{net: n, px: px}
+}
+
+// which way a call goes, and how it fares.
+func (n *MemNetwork) route(addr string) (to *Paxos, delay time.Duration, loseArgs, loseReply bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.loss > 0 {
+		if n.rng == nil {
+			n.rng = rand.New(rand.NewSource(1))
+		}
+		if n.rng.Float64() < n.loss {
+			if n.rng.Intn(2) == 0 {
+				loseArgs = true
+			} else {
+				loseReply = true
+			}
+		}
+	}
+	return n.peers[addr], n.latency, loseArgs, loseReply
+}
+
+//
+// one peer's end of a MemNetwork.
+//
+type MemTransport struct {
+	net  *MemNetwork
+	px   *Paxos
+	addr string
+}
+
+func (t *MemTransport) Listen(addr string) error {
+	t.net.mu.Lock()
+	defer t.net.mu.Unlock()
+	if t.net.peers == nil {
+		t.net.peers = map[string]*Paxos{}
+	}
+	t.addr = addr
+	t.net.peers[addr] = t.px
+	return nil
+}
+
+// calls are delivered by the caller's Call(), so there's
+// nothing to do here.
+func (t *MemTransport) Serve() {}
+
+func (t *MemTransport) Call(peer int, method string, args interface{}, reply interface{}) bool {
+	to, delay, loseArgs, loseReply := t.net.route(t.px.peers[peer])
+	if delay > 0 {
+		<-t.px.clock.After(delay)
+	}
+	if to == nil || to.isdead() {
+		return false
+	}
+	if loseArgs {
+		atomic.AddInt64(&t.net.dropped, 1)
+		return false
+	}
+
+	// the handler gets its own copy of args, and fills in
+	// its own reply, in its own goroutine.
+	theirArgs := reflect.New(reflect.TypeOf(args).Elem()).Interface()
+	if gobCopy(args, theirArgs) != nil {
+		return false
+	}
+	theirReply := reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+	done := make(chan error, 1)
+	go func() {
+		done <- to.Handle(method, theirArgs, theirReply)
+	}()
+	if err := <-done; err != nil {
+		return false
+	}
+
+	if loseReply {
+		atomic.AddInt64(&t.net.dropped, 1)
+		return false
+	}
+	return gobCopy(theirReply, reply) == nil
+}
+
+func (t *MemTransport) Close() error {
+	t.net.mu.Lock()
+	defer t.net.mu.Unlock()
+	if t.net.peers[t.addr] == t.px {
+		delete(t.net.peers, t.addr)
+	}
+	return nil
+}
+
+// copy from into to, both pointers, through gob, as if it
+// had crossed the wire.
+func gobCopy(from interface{}, to interface{}) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(from); err != nil {
+		return err
+	}
+	return gob.NewDecoder(&buf).Decode(to)
+}
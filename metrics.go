@@ -0,0 +1,169 @@
+package paxos
+
+//
+// metrics, for scraping in production.
+//
+// CollectMetrics() reports a peer's counters and gauges to a
+// MetricsRegistry, so they can be wired into whatever metrics
+// system the application already uses. MetricsHandler() does
+// that into a registry of its own on every request, and
+// serves the result in the Prometheus text format. neither
+// costs anything until it's called: the counters underneath
+// are kept regardless, and are cheap.
+//
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//
+// somewhere to report metrics to. labels may be nil. a name
+// is always reported with the same kind and help, and with
+// the same label names.
+//
+type MetricsRegistry interface {
+	Counter(name, help string, labels map[string]string, v float64)
+	Gauge(name, help string, labels map[string]string, v float64)
+}
+
+//
+// report this peer's metrics to r, as they stand now.
+//
+func (px *Paxos) CollectMetrics(r MetricsRegistry) {
+	r.Counter("paxos_proposals_total", "Proposals run by this peer.", nil,
+		float64(atomic.LoadInt64(&px.proposed)))
+	r.Counter("paxos_rounds_total", "Prepare/Accept rounds run by this peer's proposals.", nil,
+		float64(atomic.LoadInt64(&px.roundsRun)))
+	r.Counter("paxos_preemptions_total", "Rounds that lost to a higher pnum.", nil,
+		float64(atomic.LoadInt64(&px.preempts)))
+	r.Counter("paxos_decides_total", "Instances this peer has learned are decided.", nil,
+		float64(atomic.LoadInt64(&px.decides)))
+
+	s := px.Snapshot()
+	r.Gauge("paxos_instances", "Live instances.", map[string]string{"state": "decided"}, float64(s.Decided))
+	r.Gauge("paxos_instances", "Live instances.", map[string]string{"state": "pending"}, float64(s.Pending))
+	r.Gauge("paxos_min", "Min(): instances below it are forgotten.", nil, float64(s.Min))
+	r.Gauge("paxos_max", "Highest seq this peer knows of.", nil, float64(s.Max))
+	if age, ok := px.lastDecidedAge(); ok {
+		r.Gauge("paxos_last_decided_age_seconds", "Time since this peer last learned of a decision.", nil,
+			age.Seconds())
+	}
+
+	lats := px.Latencies()
+	keys := make([]string, 0, len(lats))
+	for key := range lats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		l := lats[key]
+		i := strings.Index(key, "/")
+		labels := map[string]string{"peer": key[:i], "method": key[i+1:]}
+		r.Counter("paxos_rpcs_total", "RPCs sent that got replies.", labels, float64(l.Count))
+		r.Gauge("paxos_rpc_latency_mean_seconds", "Mean RPC latency.", labels, l.Mean.Seconds())
+		r.Gauge("paxos_rpc_latency_p99_seconds", "99th percentile RPC latency, to within a factor of two.", labels,
+			l.P99.Seconds())
+	}
+}
+
+// how long since the most recent decision among the live
+// instances. ok is false if none is decided.
+func (px *Paxos) lastDecidedAge() (time.Duration, bool) {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	var last time.Time
+	for _, instance := range px.instances {
+		if instance.state == Decided && instance.decidedAt.After(last) {
+			last = instance.decidedAt
+		}
+	}
+	if last.IsZero() {
+		return 0, false
+	}
+	return px.clock.Now().Sub(last), true
+}
+
+//
+// an http.Handler serving this peer's metrics in the
+// Prometheus text format.
+//
+func (px *Paxos) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var r textRegistry
+		px.CollectMetrics(&r)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.write(w)
+	})
+}
+
+// a MetricsRegistry that keeps samples to write out as
+// Prometheus text, grouped by name in the order first seen.
+type textRegistry struct {
+	names    []string
+	families map[string]*metricFamily
+}
+
+type metricFamily struct {
+	kind    string // "counter" or "gauge"
+	help    string
+	samples []string // `{labels} value`
+}
+
+func (r *textRegistry) Counter(name, help string, labels map[string]string, v float64) {
+	r.add("counter", name, help, labels, v)
+}
+
+func (r *textRegistry) Gauge(name, help string, labels map[string]string, v float64) {
+	r.add("gauge", name, help, labels, v)
+}
+
+func (r *textRegistry) add(kind, name, help string, labels map[string]string, v float64) {
+	if r.families == nil {
+		r.families = map[string]*metricFamily{}
+	}
+	f, ok := r.families[name]
+	if !ok {
+		f = &metricFamily{kind: kind, help: help}
+		r.families[name] = f
+		r.names = append(r.names, name)
+	}
+	f.samples = append(f.samples, formatLabels(labels)+" "+strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+func (r *textRegistry) write(w io.Writer) {
+	for _, name := range r.names {
+		f := r.families[name]
+		fmt.Fprintf(w, "# HELP %s %s\n", name, f.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, f.kind)
+		for _, s := range f.samples {
+			fmt.Fprintf(w, "%s%s\n", name, s)
+		}
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels as `{a="x",b="y"}`, sorted by name, or "" if none.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(labels[name]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
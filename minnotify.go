@@ -0,0 +1,55 @@
+package paxos
+
+//
+// telling the application when Min() goes up, for
+// Config.OnMinAdvance.
+//
+// advanceMinLocked() notices Min() moving with px.mu held,
+// which is no place to call out to the application, so it
+// just pokes minAdvanced and a goroutine of its own makes
+// the calls. pokes that come while a call is running are
+// merged, so the application hears of the latest Min(),
+// not of every step on the way.
+//
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// how often minNotifier() looks to see if it's been killed,
+// when Min() isn't moving.
+const minNotifierPoll = 100 * time.Millisecond
+
+// wake minNotifier(), without waiting if it's already awake.
+func (px *Paxos) pokeMinNotifier() {
+	if px.minAdvanced == nil {
+		return
+	}
+	select {
+	case px.minAdvanced <- struct{}{}:
+	default:
+	}
+}
+
+// report Min() to Config.OnMinAdvance each time it has gone
+// up, until killed.
+func (px *Paxos) minNotifier() {
+	tick := time.NewTicker(minNotifierPoll)
+	defer tick.Stop()
+	reported := int64(0)
+	for !px.isdead() {
+		select {
+		case <-px.minAdvanced:
+		case <-tick.C:
+			continue
+		}
+		if px.isdead() {
+			return
+		}
+		if min := atomic.LoadInt64(&px.minCache); min > reported {
+			reported = min
+			px.config.OnMinAdvance(int(min))
+		}
+	}
+}
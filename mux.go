@@ -0,0 +1,181 @@
+package paxos
+
+//
+// A Mux lets many independent Paxos groups in one process
+// share a single listener. Each group's peers[] are the Mux
+// addresses of the participating processes, and RPCs are
+// routed to the right group by the GroupID in their args.
+//
+// mx, err := paxos.NewMux(addr)
+// px := mx.Make(group int, peers []string, me int)
+// mx.Close()
+//
+
+import "net"
+import "net/rpc"
+import "os"
+import "sync"
+import "sync/atomic"
+import "fmt"
+import "time"
+
+type Mux struct {
+	mu     sync.Mutex
+	l      net.Listener
+	dead   int32
+	groups map[int]*Paxos
+}
+
+// the RPC receiver registered as "Paxos" on the shared server.
+type muxService struct {
+	mx *Mux
+}
+
+//
+// create a Mux listening on addr.
+//
+func NewMux(addr string) (*Mux, error) {
+	mx := &Mux{groups: map[int]*Paxos{}}
+
+	rpcs := rpc.NewServer()
+	if err := rpcs.RegisterName("Paxos", &muxService{mx}); err != nil {
+		return nil, err
+	}
+
+	os.Remove(addr) // only needed for "unix"
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	mx.l = l
+
+	go mx.serve(rpcs)
+	return mx, nil
+}
+
+//
+// create a Paxos peer for group, served by this Mux.
+// peers[] are the addresses of the Muxes of all the
+// processes in the group; peers[me] is this Mux.
+//
+func (mx *Mux) Make(group int, peers []string, me int) *Paxos {
+	px := Make(peers, me, rpc.NewServer())
+	px.group = group
+
+	mx.mu.Lock()
+	defer mx.mu.Unlock()
+	mx.groups[group] = px
+	return px
+}
+
+//
+// shut down the listener. the groups' peers stop
+// receiving RPCs but are not otherwise killed.
+//
+func (mx *Mux) Close() {
+	atomic.StoreInt32(&mx.dead, 1)
+	mx.l.Close()
+}
+
+func (mx *Mux) isdead() bool {
+	return atomic.LoadInt32(&mx.dead) != 0
+}
+
+func (mx *Mux) serve(rpcs *rpc.Server) {
+	var tempDelay time.Duration
+	for mx.isdead() == false {
+		conn, err := mx.l.Accept()
+		if err == nil {
+			tempDelay = 0
+			go rpcs.ServeConn(conn)
+		} else if mx.isdead() == false {
+			fmt.Printf("Mux accept: %v\n", err.Error())
+			if !acceptBackoff(realClock{}, err, &tempDelay) {
+				return
+			}
+		}
+	}
+}
+
+func (mx *Mux) lookup(group int) (*Paxos, error) {
+	mx.mu.Lock()
+	defer mx.mu.Unlock()
+
+	px, ok := mx.groups[group]
+	if !ok || px.isdead() {
+		return nil, fmt.Errorf("paxos: no such group %v", group)
+	}
+	return px, nil
+}
+
+func (ms *muxService) Prepare(args *PrepareArgs, reply *PrepareReply) error {
+	px, err := ms.mx.lookup(args.GroupID)
+	if err != nil {
+		return err
+	}
+	return px.Prepare(args, reply)
+}
+
+func (ms *muxService) Accept(args *AcceptArgs, reply *AcceptReply) error {
+	px, err := ms.mx.lookup(args.GroupID)
+	if err != nil {
+		return err
+	}
+	return px.Accept(args, reply)
+}
+
+func (ms *muxService) Decide(args *DecideArgs, reply *DecideReply) error {
+	px, err := ms.mx.lookup(args.GroupID)
+	if err != nil {
+		return err
+	}
+	return px.Decide(args, reply)
+}
+
+func (ms *muxService) Query(args *QueryArgs, reply *QueryReply) error {
+	px, err := ms.mx.lookup(args.GroupID)
+	if err != nil {
+		return err
+	}
+	return px.Query(args, reply)
+}
+
+func (ms *muxService) Lease(args *LeaseArgs, reply *LeaseReply) error {
+	px, err := ms.mx.lookup(args.GroupID)
+	if err != nil {
+		return err
+	}
+	return px.Lease(args, reply)
+}
+
+func (ms *muxService) Fetch(args *FetchArgs, reply *FetchReply) error {
+	px, err := ms.mx.lookup(args.GroupID)
+	if err != nil {
+		return err
+	}
+	return px.Fetch(args, reply)
+}
+
+func (ms *muxService) StatusRange(args *StatusRangeArgs, reply *StatusRangeReply) error {
+	px, err := ms.mx.lookup(args.GroupID)
+	if err != nil {
+		return err
+	}
+	return px.StatusRange(args, reply)
+}
+
+func (ms *muxService) PreVote(args *PreVoteArgs, reply *PreVoteReply) error {
+	px, err := ms.mx.lookup(args.GroupID)
+	if err != nil {
+		return err
+	}
+	return px.PreVote(args, reply)
+}
+
+func (ms *muxService) ExchangeDone(args *ExchangeDoneArgs, reply *ExchangeDoneReply) error {
+	px, err := ms.mx.lookup(args.GroupID)
+	if err != nil {
+		return err
+	}
+	return px.ExchangeDone(args, reply)
+}
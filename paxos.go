@@ -24,14 +24,21 @@ import "net"
 import "net/rpc"
 import "log"
 
-import "os"
 import "syscall"
 import "sync"
 import "sync/atomic"
 import "fmt"
 import (
+	"context"
+	"errors"
+	"io"
+	"math"
 	"math/rand"
+	"reflect"
+	"runtime/debug"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -40,23 +47,45 @@ import (
 const (
 	OK = "OK"
 	Reject = "Reject"
+	Filtered = "Filtered" // an Accept refused by Config.AcceptFilter
+)
+
+var (
+	ErrForgotten        = errors.New("paxos: instance forgotten")
+	ErrNoMajority       = errors.New("paxos: no majority reachable")
+	ErrTooManyInstances = errors.New("paxos: too many live instances")
+	ErrMaxRounds        = errors.New("paxos: no agreement within MaxRounds rounds")
+	ErrConflict         = errors.New("paxos: different values accepted with the same pnum")
+	ErrNoPeers          = errors.New("paxos: no peers")
+	ErrDropped          = errors.New("paxos: message dropped by Config.Intercept")
+	ErrValueTooLarge    = errors.New("paxos: value larger than Config.MaxValueBytes")
+	ErrDryRun           = errors.New("paxos: dry run, nothing sent")
+	ErrFiltered         = errors.New("paxos: value refused by Config.AcceptFilter")
+	ErrBusy             = errors.New("paxos: Config.MaxProposers proposals already running")
+	ErrCancelled        = errors.New("paxos: proposal cancelled")
+	ErrNothingAccepted  = errors.New("paxos: no value accepted to learn")
+	ErrPNumExhausted    = errors.New("paxos: pnum counter has reached its limit")
+	ErrNotLeader        = errors.New("paxos: another peer holds the leader lease")
 )
 
 type PrepareArgs struct {
 	Seq int		//the instance id
 	PNum string	//the epoch number
+	GroupID int	//the paxos group, when sharing a Mux
 }
 
 type PrepareReply struct {
 	Err string
 	AcceptPnum string
 	AcceptValue interface {}
+	HasAccepted bool // AcceptValue was accepted, even if it's nil
 }
 
 type AcceptArgs struct {
 	Seq int
 	PNum string
 	Value interface {}
+	GroupID int
 }
 
 type AcceptReply struct  {
@@ -69,12 +98,25 @@ type DecideArgs struct {
 	PNum string
 	Me int
 	Done int
+	GroupID int
 }
 
 type DecideReply struct {
 
 }
 
+type QueryArgs struct {
+	Seq int
+	GroupID int
+}
+
+type QueryReply struct {
+	Err string
+	State Fate		// the peer's view of the instance
+	PNum string		// accepted epoch num
+	Value interface {}	// accepted value
+}
+
 // helper functions
 func (px *Paxos) newInstance() *instance {
 	return &instance{n_a: "", n_p: "", v_a: nil, state: Pending}
@@ -85,12 +127,110 @@ func (px *Paxos) majority() int {
 }
 
 // generate a proposer num
-func (px *Paxos) generatePNum() string {
+// the counter is the time since a fixed epoch, but never at
+// or below one this peer has already issued, so pnums keep
+// increasing even if the clock steps backward (say, an NTP
+// correction) or two are generated in the same tick. only
+// within one run, though: a peer restarted while its clock
+// is behind can still issue lower pnums than before.
+//
+// the counter is written in decimal, as wide as it needs,
+// and comparePNum() orders counters by width first, so the
+// encoding itself can't overflow. the int64 it's kept in
+// lasts until about 2309, unless a clock far in the future
+// (time.Sub() saturates) or a flood of pnums pushes it to
+// pnumLimit, at which point generatePNum() returns
+// ErrPNumExhausted rather than wrap around to pnums lower
+// than every one before.
+func (px *Paxos) generatePNum() (string, error) {
+	return px.generatePNumAhead(0)
+}
+
+// like generatePNum(), but as if the clock were ahead by
+// ahead, for a pnum that outbids the others' for that long.
+func (px *Paxos) generatePNumAhead(ahead time.Duration) (string, error) {
 	begin := time.Date(2017, time.April, 4, 19, 0, 0, 0, time.UTC)
-	duration := time.Now().Sub(begin)
-	return strconv.FormatInt(duration.Nanoseconds(), 10) + "-" + strconv.Itoa(px.me)
+	n := px.clock.Now().Sub(begin).Nanoseconds() + ahead.Nanoseconds()
+	for {
+		last := atomic.LoadInt64(&px.lastPNum)
+		if n <= last {
+			n = last + 1
+		}
+		if n >= pnumLimit {
+			return "", ErrPNumExhausted
+		}
+		if atomic.CompareAndSwapInt64(&px.lastPNum, last, n) {
+			break
+		}
+	}
+	return strconv.FormatInt(n, 10) + "-" + strconv.Itoa(px.me), nil
+}
+
+// the highest pnum counter generatePNum() will issue, less
+// one; far enough below math.MaxInt64 that last+1 can't wrap.
+const pnumLimit = math.MaxInt64 - 1<<20
+
+//
+// may an acceptor that has promised promised grant a
+// Prepare or Accept for pnum? yes if pnum is at least as
+// high. pnums carry their proposer's index, so an equal one
+// can only be the same proposer re-sending the same round,
+// e.g. after losing replies, and granting it again changes
+// nothing. a higher pnum from the same proposer is a new
+// round of its own, which supersedes its old promise and
+// may replace its old accept with the value it chose then.
+//
+func grantable(pnum, promised string) bool {
+	return comparePNum(pnum, promised) >= 0
+}
+
+// compare two proposer nums, returning -1, 0 or +1.
+// pnums look like "<nanoseconds>-<me>" and are compared
+// numerically, so "9-1" < "10-0" even though a plain string
+// comparison says otherwise. "" (none yet) is lowest.
+func comparePNum(a, b string) int {
+	an, am, aok := splitPNum(a)
+	bn, bm, bok := splitPNum(b)
+	if !aok || !bok {
+		return strings.Compare(a, b)
+	}
+	if len(an) != len(bn) {
+		if len(an) < len(bn) {
+			return -1
+		}
+		return 1
+	}
+	if c := strings.Compare(an, bn); c != 0 {
+		return c
+	}
+	if am != bm {
+		if am < bm {
+			return -1
+		}
+		return 1
+	}
+	return 0
 }
 
+// split a pnum into its counter, with leading zeros
+// stripped, and its proposer index.
+func splitPNum(pnum string) (string, int, bool) {
+	i := strings.LastIndex(pnum, "-")
+	if i < 0 {
+		return "", 0, false
+	}
+	n := pnum[:i]
+	for _, c := range n {
+		if c < '0' || c > '9' {
+			return "", 0, false
+		}
+	}
+	me, err := strconv.Atoi(pnum[i+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return strings.TrimLeft(n, "0"), me, true
+}
 
 // px.Status() return values, indicating
 // whether an agreement has been decided,
@@ -104,25 +244,93 @@ const (
 	Forgotten      // decided but forgotten.
 )
 
+func (f Fate) String() string {
+	switch f {
+	case Decided:
+		return "Decided"
+	case Pending:
+		return "Pending"
+	case Forgotten:
+		return "Forgotten"
+	}
+	return "Fate(" + strconv.Itoa(int(f)) + ")"
+}
+
 type instance struct {
 	state Fate        // instance state
 	n_p   string      // proposed epoch num
 	n_a   string      // accepted epoch num
 	v_a   interface{} // accepted value
+
+	promisedAt time.Time // when n_p was last promised, for PreVote
+	acceptedAt time.Time // when v_a was last accepted, for the completer
+	decidedAt  time.Time // when this peer learned it was decided
+	rounds     int       // rounds this peer's proposers have run for it
 }
 
 type Paxos struct {
+	lastPNum   int64 // counter of the last pnum generated; first, for 64-bit atomic alignment
+	minCache   int64 // Min() as of the last time it moved, for Status() to read without locking
+	inFlight   int64 // proposals holding a Config.MaxProposers slot
+	peakFlight int64 // the most inFlight has been, for testing
+	collisions int64 // Append() tries that lost their seq to another value, for testing
+	proposed   int64 // proposals run by this peer, for metrics
+	roundsRun  int64 // rounds those proposals ran
+	preempts   int64 // rounds that lost to a higher pnum
+	decides    int64 // instances this peer has learned are decided
 	mu         sync.Mutex
 	l          net.Listener
 	dead       int32 // for testing
 	unreliable int32 // for testing
+	paused     int32 // rejecting Prepares and Accepts
 	rpcCount   int32 // for testing
 	peers      []string // peers, index as id, str as ports
 	me         int // index into peers[]
+	group      int // GroupID stamped on outgoing RPCs when sharing a Mux
+	clock      Clock // source of time, for pnums and backoff
+	config     Config
+	transport  Transport // how calls reach the other peers
 
 	// Your data here.
 	dones []int	// the state of each peer
+	minFloor int // Min() has reached this, so never goes below it
+	maxSeen  int // highest seq ever in instances, for Max()
+	wins     map[int]int // peer -> instances decided with its pnum
 	instances	map[int]*instance // save the <Seq, instance> pair
+	waiters    map[int][]chan struct{} // closed when seq is decided
+	resend     []map[int]DecideArgs    // per peer, seq -> Decide it missed, see retryDecides()
+
+	leaseHolder int       // the peer we've granted a leader lease to, or -1
+	leaseExpiry time.Time // when that grant runs out
+	leaseUntil  time.Time // when our own majority-granted lease runs out
+	leaseBarrier int      // highest seq that may be decided without us knowing, see LeaseRead()
+
+	latMu     sync.Mutex
+	latencies map[string]*latencyHist // "<peer>/<method>" -> RPC latencies
+
+	uniques    map[string]uniqueEntry // StartUnique() key -> seq
+	uniqueKeys []string               // uniques' keys, oldest first
+
+	unflushed map[string][]byte // saves DurabilityBuffered hasn't flushed yet
+
+	rngMu sync.Mutex
+	rng   *rand.Rand // for randomized backoff, see backoff.go
+	seed  int64      // what rng was seeded from
+
+	pool   connPool          // clients to other peers, for Config.ReuseConns
+	connMu sync.Mutex
+	conns  map[net.Conn]bool // connections being served, closed by Kill()
+
+	proposers chan struct{}    // a slot per running proposal, for Config.MaxProposers
+	queued    []queuedProposal // Start()s waiting for one of those slots
+
+	minAdvanced chan struct{} // poked when Min() goes up, for Config.OnMinAdvance
+
+	proposals map[int][]chan struct{} // seq -> running proposers' cancel channels
+
+	spilled map[int]bool // instances moved out to the Persister, see spill.go
+
+	probed chan struct{} // closed when serve() sees Make()'s probe, see selfcheck.go
 }
 
 //
@@ -142,77 +350,270 @@ type Paxos struct {
 // please do not change this function.
 //
 func call(srv string, name string, args interface{}, reply interface{}) bool {
-	c, err := rpc.Dial("unix", srv)
-	if err != nil {
-		err1 := err.(*net.OpError)
-		if err1.Err != syscall.ENOENT && err1.Err != syscall.ECONNREFUSED {
-			fmt.Printf("paxos Dial() failed: %v\n", err1)
+	err := callErr(srv, name, args, reply)
+	if err == nil {
+		return true
+	}
+
+	if errors.Is(err, ErrDial) {
+		if !errors.Is(err, syscall.ENOENT) && !errors.Is(err, syscall.ECONNREFUSED) {
+			fmt.Printf("paxos Dial() failed: %v\n", errors.Unwrap(err))
 		}
 		return false
 	}
+	fmt.Println(errors.Unwrap(err))
+	return false
+}
+
+// the kinds of CallError.
+var (
+	ErrDial    = errors.New("can't connect")          // the server wasn't reached
+	ErrNoReply = errors.New("no reply")               // lost or timed out; the handler may have run
+	ErrCodec   = errors.New("can't encode or decode") // args or reply don't fit through gob
+	ErrHandler = errors.New("handler failed")         // the handler returned an error
+)
+
+//
+// why an RPC sent by callErr() failed. errors.Is()
+// matches the Kind, so callers can tell failures worth
+// retrying (ErrDial, ErrNoReply) from ones that aren't.
+//
+type CallError struct {
+	Srv  string
+	Name string
+	Kind error
+	Err  error // what net/rpc said
+}
+
+func (e *CallError) Error() string {
+	return fmt.Sprintf("paxos: %v to %v: %v: %v", e.Name, e.Srv, e.Kind, e.Err)
+}
+
+func (e *CallError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *CallError) Unwrap() error {
+	return e.Err
+}
+
+//
+// like call(), but returns nil on success or a *CallError
+// saying what went wrong, and prints nothing.
+//
+func callErr(srv string, name string, args interface{}, reply interface{}) error {
+	c, err := rpc.Dial("unix", srv)
+	if err != nil {
+		return &CallError{srv, name, ErrDial, err}
+	}
 	defer c.Close()
 
 	err = c.Call(name, args, reply)
 	if err == nil {
-		return true
+		return nil
 	}
+	return classifyCallErr(srv, name, err)
+}
 
-	fmt.Println(err)
-	return false
+// the *CallError for err, returned by net/rpc's Call().
+func classifyCallErr(srv string, name string, err error) error {
+	kind := ErrCodec
+	var ne net.Error
+	if _, ok := err.(rpc.ServerError); ok {
+		kind = ErrHandler
+	} else if err == rpc.ErrShutdown || err == io.EOF || err == io.ErrUnexpectedEOF {
+		kind = ErrNoReply
+	} else if errors.As(err, &ne) {
+		// timed out, or the connection broke
+		kind = ErrNoReply
+	}
+	return &CallError{srv, name, kind, err}
+}
+
+// are a and b the same value? see Config.ValueEqual.
+func (px *Paxos) valueEqual(a, b interface{}) bool {
+	if px.config.ValueEqual != nil {
+		return px.config.ValueEqual(a, b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// should this peer handle a method call with args? see
+// Config.Intercept.
+func (px *Paxos) intercept(method string, args interface{}) bool {
+	return px.config.Intercept == nil || px.config.Intercept(method, args)
+}
+
+// log through Config.Logger if set, else the standard logger.
+func (px *Paxos) logf(format string, args ...interface{}) {
+	if px.config.Logger != nil {
+		px.config.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// turn a panic in an RPC handler into an error reply, so one
+// bad request can't take the whole peer down. net/rpc runs
+// each call in its own goroutine, so this has to be in the
+// handlers, not around ServeConn(). handlers defer it first,
+// so their deferred Unlock()s have run by the time it does.
+// a *SafetyViolation goes through: StrictSafety wants a crash.
+func (px *Paxos) recoverHandler(method string, err *error) {
+	if r := recover(); r != nil {
+		if _, ok := r.(*SafetyViolation); ok {
+			panic(r)
+		}
+		px.logf("Paxos(%v) %v panicked: %v\n%s", px.me, method, r, debug.Stack())
+		*err = fmt.Errorf("paxos: %v panicked: %v", method, r)
+	}
+}
+
+// peer i's current address, from Config.Resolver if set.
+func (px *Paxos) resolve(i int) string {
+	if px.config.Resolver != nil {
+		return px.config.Resolver(i)
+	}
+	return px.peers[i]
 }
 
+//
+// send an RPC to peer i, or if i is this peer, call the
+// handler directly. returns true if the peer replied, like
+// call(), so that callers can treat every peer the same way.
+//
+func (px *Paxos) invoke(i int, name string, args interface{}, reply interface{}) bool {
+	if i != px.me {
+		start := px.clock.Now()
+		ok := px.transport.Call(i, name, args, reply)
+		if ok {
+			px.recordLatency(i, name, px.clock.Now().Sub(start))
+		}
+		px.detect(i, ok)
+		return ok
+	}
+	return px.Handle(name, args, reply) == nil
+}
 
 // LabLabLab
-func (px *Paxos) Prepare(args *PrepareArgs, reply *PrepareReply) error {
+func (px *Paxos) Prepare(args *PrepareArgs, reply *PrepareReply) (err error) {
+	defer px.recoverHandler("Paxos.Prepare", &err)
 	// Your code here
 	//first add the lock
+	if !px.intercept("Paxos.Prepare", args) {
+		return ErrDropped
+	}
+	defer px.syncWAL() // after unlocking, to share the fsync
 	px.mu.Lock()
 	defer px.mu.Unlock();
+	px.prepareLocked(args, reply)
+	return nil
+}
+
+// the acceptor's half of Prepare, for callers that
+// already hold px.mu.
+func (px *Paxos) prepareLocked(args *PrepareArgs, reply *PrepareReply) {
+	if px.config.StrictSafety {
+		defer px.checkLocked(args.Seq, px.copyInstanceLocked(args.Seq))
+	}
+	//paused peers don't vote
+	if px.ispaused() {
+		reply.Err = Reject
+		return
+	}
 	//then check the Seq
 	//maxseq := px.Max()
-	_,ok := px.instances[args.Seq]
-	if !ok {
-		px.instances[args.Seq]=px.newInstance()
-	}	
+	if _, err := px.getOrCreateLocked(args.Seq); err != nil {
+		reply.Err = Reject
+		return
+	}
 	maxseq := px.instances[args.Seq].n_p
 	//set the reply
 	//如果提议号大于接受者最大提议号，或目前无最大提议号，更新提议值和提议号
-	if (args.PNum >= maxseq ) {
+	if (grantable(args.PNum, maxseq) && !px.leasedAwayLocked(args.PNum)) {
 		reply.Err = OK
 		px.instances[args.Seq].n_p = args.PNum
+		px.instances[args.Seq].promisedAt = px.clock.Now()
+		px.logInstanceLocked(args.Seq)
+		px.auditLocked(AuditPrepareGranted, args.Seq, args.PNum, nil)
 	}else{//如果提议号小于目前最大提议号,拒绝
 		reply.Err = Reject
 		//reply.AcceptPnum = maxseq
 	}
-	reply.AcceptValue = px.instances[args.Seq].v_a
+	reply.AcceptValue = px.packValue(px.instances[args.Seq].v_a)
 	reply.AcceptPnum = px.instances[args.Seq].n_a
-	return nil
+	reply.HasAccepted = px.instances[args.Seq].n_a != ""
 }
 
 // LabLabLab
-func (px *Paxos) Accept(args *AcceptArgs, reply *AcceptReply) error {
+func (px *Paxos) Accept(args *AcceptArgs, reply *AcceptReply) (err error) {
+	defer px.recoverHandler("Paxos.Accept", &err)
 	// Your code here
 	// first add the lock
+	if !px.intercept("Paxos.Accept", args) {
+		return ErrDropped
+	}
+	defer px.syncWAL()
 	px.mu.Lock()
 	defer px.mu.Unlock()
+	px.acceptLocked(args, reply)
+	return nil
+}
+
+// the acceptor's half of Accept, for callers that
+// already hold px.mu.
+func (px *Paxos) acceptLocked(args *AcceptArgs, reply *AcceptReply) {
+	if px.config.StrictSafety {
+		defer px.checkLocked(args.Seq, px.copyInstanceLocked(args.Seq))
+	}
+	if px.ispaused() || px.leasedAwayLocked(args.PNum) {
+		reply.Err = Reject
+		return
+	}
+	if px.config.AcceptFilter != nil && !px.config.AcceptFilter(args.Seq, unpackValue(args.Value)) {
+		reply.Err = Filtered
+		return
+	}
 	// then check the Seq
 	
 	_,ok := px.instances[args.Seq]
 	//未prepare，拒绝
+	// (Accept never creates an instance: that's getOrCreateLocked()'s
+	// job, on the Prepare that must come first. it does bring a
+	// spilled one back, and one that can't be read is refused.)
+	if !ok && px.spilled[args.Seq] {
+		if _, err := px.getOrCreateLocked(args.Seq); err != nil {
+			px.logf("Paxos(%v) %v", px.me, err)
+		} else {
+			ok = true
+		}
+	}
 	if !ok {
 		/*px.instances[args.Seq] = px.newInstance()
 		px.instances[args.Seq].n_p = args.PNum
 		px.instances[args.Seq].n_a = args.PNum
 		px.instances[args.Seq].v_a = args.Value*/
 		reply.Err = Reject
+	}else if px.instances[args.Seq].state == Decided {
+		// the value is final, whatever the pnum: a zombie
+		// proposer's Accept mustn't overwrite it. one for
+		// the decided value changes nothing, so it's fine.
+		if px.valueEqual(unpackValue(args.Value), px.instances[args.Seq].v_a) {
+			reply.Err = OK
+		} else {
+			reply.Err = Reject
+		}
 	}else{
 		maxseq := px.instances[args.Seq].n_p
 		//以前提议号小于等于当前提议号，更新提议号和提议值
-		if(args.PNum >= maxseq){
+		if(grantable(args.PNum, maxseq)){
 			reply.Err = OK
 			px.instances[args.Seq].n_p = args.PNum
 			px.instances[args.Seq].n_a = args.PNum
-			px.instances[args.Seq].v_a = args.Value
+			px.instances[args.Seq].v_a = unpackValue(args.Value)
+			px.instances[args.Seq].acceptedAt = px.clock.Now()
+			px.logInstanceLocked(args.Seq)
+			px.auditLocked(AuditAcceptGranted, args.Seq, args.PNum, px.instances[args.Seq].v_a)
 			//px.instances[args.Seq].state = Decided
 			//px.dones[args.Me] = args.Done
 
@@ -225,90 +626,344 @@ func (px *Paxos) Accept(args *AcceptArgs, reply *AcceptReply) error {
 	
 	// set the reply
 	
-	return nil
 }
 
 //accept the decided value from others
-func (px *Paxos) Decide(args *DecideArgs, reply *DecideReply) error {
+func (px *Paxos) Decide(args *DecideArgs, reply *DecideReply) (err error) {
+	defer px.recoverHandler("Paxos.Decide", &err)
 	// Your code here
+	if !px.intercept("Paxos.Decide", args) {
+		return ErrDropped
+	}
 	// first add the lock
+	defer px.syncWAL()
 	px.mu.Lock()
 	defer px.mu.Unlock()
 	//fmt.Println("Decide: %d, %d, %s", px.me, args.Seq, args.PNum)
 
-	//then new the instance if not exist
-	_, exist := px.instances[args.Seq]
-	if !exist {
-		px.instances[args.Seq] = px.newInstance()
+	px.decideLocked(args.Seq, args.PNum, unpackValue(args.Value))
+	// update the server done array. a Decide can arrive
+	// after a later one, with an older Done, so never go back.
+	if args.Done > px.dones[args.Me] {
+		px.dones[args.Me] = args.Done
+		px.advanceMinLocked()
 	}
-
-	//update the num and value
-    // update proposer number,accept num and value,state
-	px.instances[args.Seq].v_a = args.Value
-	px.instances[args.Seq].n_a = args.PNum
-	px.instances[args.Seq].n_p = args.PNum
-	px.instances[args.Seq].state = Decided
-    // update the server done array
-	px.dones[args.Me] = args.Done
 	return nil
 }
 
+// record that seq was decided as v, with pnum.
+// caller must hold px.mu.
+func (px *Paxos) decideLocked(seq int, pnum string, v interface{}) {
+	if px.config.StrictSafety {
+		defer px.checkLocked(seq, px.copyInstanceLocked(seq))
+	}
+	//new the instance if not exist, unless it's been
+	//forgotten already and this decision is stale
+	if seq > px.lowestDoneLocked() && px.setState(seq, Decided) {
+		//update the num and value
+		// update proposer number,accept num and value,state
+		px.instances[seq].v_a = v
+		px.instances[seq].n_a = pnum
+		px.instances[seq].n_p = pnum
+		if px.instances[seq].decidedAt.IsZero() {
+			px.instances[seq].decidedAt = px.clock.Now()
+			atomic.AddInt64(&px.decides, 1)
+			if _, who, ok := splitPNum(pnum); ok {
+				px.wins[who]++
+			}
+			px.auditLocked(AuditDecided, seq, pnum, v)
+		}
+		px.logInstanceLocked(seq)
+		px.notifyLocked(seq)
+	}
+}
 
-func (px *Paxos) sendAccept(seq int, pnum string, v interface{}) bool {
-	acargs := AcceptArgs{seq,pnum,v}
-	accNum := 0
-	for i,peer := range px.peers{
-		acreply := AcceptReply{}
+// report this peer's view of an instance, without changing it
+func (px *Paxos) Query(args *QueryArgs, reply *QueryReply) (err error) {
+	defer px.recoverHandler("Paxos.Query", &err)
+	px.mu.Lock()
+	defer px.mu.Unlock()
 
-		if(i == px.me){
-			px.Accept(&acargs,&acreply)
-		}else{
-			call(peer, "Paxos.Accept", &acargs, &acreply)
+	reply.Err = OK
+	if args.Seq < px.minLocked() {
+		reply.State = Forgotten
+		return nil
+	}
+	instance, exist := px.lookupLocked(args.Seq)
+	if !exist {
+		reply.State = Pending
+		return nil
+	}
+	reply.State = instance.state
+	reply.PNum = instance.n_a
+	reply.Value = instance.v_a
+	return nil
+}
+
+// note that peer i didn't get the Decide in args, for
+// retryDecides() to resend. caller must hold px.mu.
+func (px *Paxos) missedDecideLocked(i int, args DecideArgs) {
+	if px.resend[i] == nil {
+		px.resend[i] = map[int]DecideArgs{}
+		go px.retryDecides(i)
+	}
+	px.resend[i][args.Seq] = args
+}
+
+//
+// keep resending peer i the Decides it missed, lowest seq
+// first, backing off from Config.DecideRetryInterval to
+// Config.DecideRetryMax while it can't be reached, until
+// each is delivered or its instance is forgotten here. one
+// of these runs per peer that has missed any, however many
+// it has missed.
+//
+func (px *Paxos) retryDecides(i int) {
+	delay := px.config.DecideRetryInterval
+	for px.isdead() == false {
+		<-px.clock.After(delay)
+
+		px.mu.Lock()
+		min := px.minLocked()
+		var seqs []int
+		for seq := range px.resend[i] {
+			if seq < min {
+				delete(px.resend[i], seq)
+			} else {
+				seqs = append(seqs, seq)
+			}
+		}
+		if len(seqs) == 0 {
+			px.resend[i] = nil
+			px.mu.Unlock()
+			return
+		}
+		done := px.dones[px.me]
+		px.mu.Unlock()
+
+		sort.Ints(seqs)
+		delivered := false
+		for _, seq := range seqs {
+			px.mu.Lock()
+			args, ok := px.resend[i][seq]
+			px.mu.Unlock()
+			if !ok {
+				continue
+			}
+			args.Done = done
+			var reply DecideReply
+			if !px.invoke(i, "Paxos.Decide", &args, &reply) {
+				// still unreachable; the rest can wait too.
+				break
+			}
+			delivered = true
+			px.mu.Lock()
+			delete(px.resend[i], seq)
+			px.mu.Unlock()
+		}
+		if delivered {
+			delay = px.config.DecideRetryInterval
+		} else if delay *= 2; px.config.DecideRetryMax > 0 && delay > px.config.DecideRetryMax {
+			delay = px.config.DecideRetryMax
+		}
+	}
+}
 
+// send Accepts for v to every peer. ok is true if a
+// majority accepted; preempted is true if any peer
+// refused because it had promised a higher pnum. decided
+// is true if this peer learned partway through that seq
+// has been decided, in which case the rest aren't sent.
+// filtered is true if a majority's AcceptFilter refused v,
+// so it can never be decided.
+func (px *Paxos) sendAccept(seq int, pnum string, v interface{}, span Span) (ok bool, preempted bool, decided bool, filtered bool) {
+	acargs := AcceptArgs{Seq: seq, PNum: pnum, Value: v, GroupID: px.group}
+	px.mu.Lock()
+	if seq > px.leaseBarrier {
+		px.leaseBarrier = seq
+	}
+	px.mu.Unlock()
+	accNum := 0
+	filteredNum := 0
+	var granted []int
+	defer func() { recordResponders(span, granted) }()
+	for i := range px.peers{
+		if px.isDecided(seq) {
+			return false, false, true, false
 		}
+		acreply := AcceptReply{}
+		replied := px.invoke(i, "Paxos.Accept", &acargs, &acreply)
 		if(acreply.Err == OK){
 			accNum+=1
+			granted = append(granted, i)
+		} else if acreply.Err == Filtered {
+			filteredNum++
+		} else if replied {
+			preempted = true
 		}
 	}
     // return if qurom accept
-	return accNum >= px.majority()
+	return accNum >= px.majority(), preempted, false, filteredNum >= px.majority()
+}
+
+// tell every peer that value, accepted by a majority with
+// pnum, is decided for seq.
+func (px *Paxos) sendDecide(seq int, pnum string, value interface{}) {
+	px.mu.Lock()
+	done := px.dones[px.me]
+	px.mu.Unlock()
+	decargs := DecideArgs{Seq: seq, Value: value, PNum: pnum,
+		Me: px.me, Done: done, GroupID: px.group}
+	for i := range px.peers {
+		var decreply DecideReply
+		if !px.invoke(i, "Paxos.Decide", &decargs, &decreply) && px.config.DecideRetryInterval > 0 {
+			px.mu.Lock()
+			px.missedDecideLocked(i, decargs)
+			px.mu.Unlock()
+		}
+	}
+}
+
+// send this peer's record of decided instance seq to the
+// other peers, along with its Done().
+func (px *Paxos) sendDecided(seq int) {
+	px.mu.Lock()
+	instance, ok := px.lookupLocked(seq)
+	if !ok || instance.state != Decided {
+		px.mu.Unlock()
+		return
+	}
+	args := DecideArgs{Seq: seq, Value: px.packValue(instance.v_a), PNum: instance.n_a,
+		Me: px.me, Done: px.dones[px.me], GroupID: px.group}
+	px.mu.Unlock()
+	for i := range px.peers {
+		if i != px.me {
+			var reply DecideReply
+			px.invoke(i, "Paxos.Decide", &args, &reply)
+		}
+	}
+}
+
+// has this peer learned that seq is decided?
+func (px *Paxos) isDecided(seq int) bool {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	instance, ok := px.lookupLocked(seq)
+	return ok && instance.state == Decided
 }
 
 
 
+// choose the value to send Accepts for: the value accepted
+// with the highest pnum among the OK prepare replies, or
+// v if none of them has accepted anything. an accepted
+// nil counts, and is chosen like any other value.
+// pnums are unique, so two replies with the same pnum must
+// carry the same value; if they don't, returns ErrConflict
+// along with the value whose %v form sorts first, so every
+// proposer that sees the same replies chooses alike.
+// values are compared with equal.
+func selectValue(v interface{}, replies []PrepareReply, equal func(a, b interface{}) bool) (interface{}, error) {
+	maxprenum := ""
+	value := v
+	var err error
+	for _, reply := range replies {
+		if reply.Err != OK || !reply.HasAccepted {
+			continue
+		}
+		c := comparePNum(reply.AcceptPnum, maxprenum)
+		if c > 0 {
+			maxprenum = reply.AcceptPnum
+			value = reply.AcceptValue
+			err = nil
+		} else if c == 0 && !equal(reply.AcceptValue, value) {
+			err = ErrConflict
+			if fmt.Sprint(reply.AcceptValue) < fmt.Sprint(value) {
+				value = reply.AcceptValue
+			}
+		}
+	}
+	return value, err
+}
 
 // LabLabLab
-func (px *Paxos) propose(seq int, v interface{}) {
+// if fixed isn't "", every round uses it as the pnum.
+// returns ErrMaxRounds if Config.MaxRounds rounds went by
+// without seq being decided, ErrCancelled if cancel is
+// closed, at the end of the round it's closed in,
+// ErrNotLeader if another peer is leader, and
+// ErrPNumExhausted if this peer has run out of pnums.
+func (px *Paxos) propose(seq int, v interface{}, fixed string, cancel <-chan struct{}) error {
 	// Your code here
+	if px.config.DryRun {
+		return px.dryPropose(seq, v, fixed)
+	}
 	//fmt.Println("%d, try to propose: %d", px.me, seq)
-	for {
-		
-
-		pnum := px.generatePNum()
-		prepareargs := PrepareArgs{seq,pnum}
+	pnum := fixed
+	bump := fixed == ""
+	var delay time.Duration
+	ran := 0
+	streak := 0 // rounds preempted in a row
+	// the value last sent out to be accepted under pnum. a
+	// round that reuses pnum must send the same one, or two
+	// values could be accepted under one pnum.
+	var sent interface{}
+	hasSent := false
+	defer func() { px.addRounds(seq, ran) }()
+	atomic.AddInt64(&px.proposed, 1)
+	for rounds := 1; px.isdead() == false; rounds++ {
+		if px.following() {
+			return ErrNotLeader
+		}
+		ran = rounds
+		atomic.AddInt64(&px.roundsRun, 1)
+
+		// a new pnum only helps if someone has promised a
+		// higher one; if messages were just lost, the old
+		// one is as good and doesn't leapfrog our own.
+		if bump {
+			var err error
+			if pnum, err = px.nextPNum(streak); err != nil {
+				px.logf("Paxos(%v) giving up on instance %v: %v", px.me, seq, err)
+				return err
+			}
+			hasSent = false
+		}
+		preempted := false
+		round := px.startRound(seq, pnum)
+		prepareargs := PrepareArgs{Seq: seq, PNum: pnum, GroupID: px.group}
 			
 		acnum := 0
-		maxprenum := ""
-		maxacval := v
-		for i, peer := range px.peers{
+		var granted []int
+		replies := make([]PrepareReply, len(px.peers))
+		// with PreVote, don't disturb anyone's promises unless
+		// a majority would go along; the round just fails.
+		span := round.Child("Prepare")
+		prepare := px.preVote(seq, pnum, &preempted)
+		for i := 0; i < len(px.peers) && prepare; i++ {
 			preparereply := PrepareReply{AcceptValue: nil, AcceptPnum: "", Err: Reject}
-			if(i == px.me){
-				px.Prepare(&prepareargs,&preparereply)
-
-			}else{
-				call(peer, "Paxos.Prepare", &prepareargs, &preparereply)
-			}
+			replied := px.invoke(i, "Paxos.Prepare", &prepareargs, &preparereply)
 			if(preparereply.Err == OK){
 				acnum +=1
-				if(preparereply.AcceptPnum > maxprenum){
-					maxprenum = preparereply.AcceptPnum
-					maxacval = preparereply.AcceptValue
-				}
+				granted = append(granted, i)
+			} else if replied {
+				preempted = true
 			}
+			preparereply.AcceptValue = unpackValue(preparereply.AcceptValue)
+			replies[i] = preparereply
+		}
+		recordResponders(span, granted)
+		span.End()
+		maxacval, err := selectValue(v, replies, px.valueEqual)
+		if err != nil {
+			px.logf("Paxos(%v) seq %v pnum %v: %v", px.me, seq, pnum, err)
+		}
+		if hasSent {
+			maxacval = sent
 		}
 
 		ok := false
-		value := maxacval
+		value := px.packValue(maxacval)
 		//超过半数prepare的OK回应
 		if(acnum >= px.majority()){
 			ok = true
@@ -316,22 +971,30 @@ func (px *Paxos) propose(seq int, v interface{}) {
 		//ok, pnum, value := px.sendPrepare(seq, v)
 		
 		if ok {
-			ok = px.sendAccept(seq, pnum, value)
+			sent, hasSent = maxacval, true
+			span = round.Child("Accept")
+			var decided, filtered bool
+			ok, preempted, decided, filtered = px.sendAccept(seq, pnum, value, span)
+			span.End()
+			if filtered {
+				round.End()
+				return ErrFiltered
+			}
+			if decided {
+				// no point finishing the round, but pass the
+				// decision on: it carries our Done(), and not
+				// every peer may have learned it.
+				px.sendDecided(seq)
+				round.End()
+				break
+			}
 		}
 
 		if(ok){
-			decargs := DecideArgs{Seq: seq, Value: value, PNum: pnum, //maxacval
-				Me: px.me, Done: px.dones[px.me]}
-			for i, peer := range px.peers {
-				var decreply DecideReply
-				//fmt.Println("sendDecide: %d, %d, %s", px.me, decargs.Seq, decargs.PNum)
-				if i == px.me {
-					px.Decide(&decargs, &decreply)
-
-				} else {
-					call(peer, "Paxos.Decide", &decargs, &decreply)
-				}
-			}
+			span = round.Child("Decide")
+			px.sendDecide(seq, pnum, value)
+			span.End()
+			round.End()
 			break
 		}
 
@@ -354,11 +1017,44 @@ func (px *Paxos) propose(seq int, v interface{}) {
 			break
 		}*/
 
+		round.End()
 		state, _ := px.Status(seq)
 		if state == Decided {
 			break
 		}
+		if px.config.MaxRounds > 0 && rounds >= px.config.MaxRounds {
+			return ErrMaxRounds
+		}
+		if cancelled(cancel) {
+			return ErrCancelled
+		}
+
+		if preempted {
+			streak++
+			atomic.AddInt64(&px.preempts, 1)
+			bump = fixed == ""
+			delay = 0
+			if px.config.PreemptBackoff > 0 {
+				select {
+				case <-px.clock.After(px.randDuration(px.config.PreemptBackoff)):
+				case <-cancel:
+				}
+			}
+		} else {
+			streak = 0
+			bump = false
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			select {
+			case <-px.clock.After(delay):
+			case <-cancel:
+			}
+		}
 	}
+	return nil
 }
 
 
@@ -371,52 +1067,378 @@ func (px *Paxos) propose(seq int, v interface{}) {
 // instance seq, with proposed value v.
 // Start() returns right away; the application will
 // call Status() to find out if/when agreement
-// is reached.
+// is reached. while another peer holds the leader lease
+// this peer doesn't propose at all, since its Prepares
+// would only be refused; see Leader().
 //
 func (px *Paxos) Start(seq int, v interface{}) {
 	// Your code here.
 	//try to propose
-	if seq < px.Min() {
-		return
-	}
-	go func() {
-		px.propose(seq, v)
-	} ()
+	px.startOrQueue(seq, "", v)
 }
 
 //
-// the application on this machine is done with
-// all instances <= seq.
+// like Start(), but propose with pnum rather than a fresh
+// proposer num, for recovery tools that need to outbid a
+// stuck proposer. pnum must look like "<counter>-<me>".
 //
-// see the comments for Min() for more explanation.
+// this is dangerous: pnums must be unique per proposer, so
+// reusing one another peer has issued (or will issue)
+// breaks Paxos's safety guarantee. every round of this
+// proposal uses pnum, so if a higher one has already been
+// promised the proposal only ends once someone else decides
+// the instance.
 //
-func (px *Paxos) Done(seq int) {
-	// Your code here.
-	px.mu.Lock()
-	defer px.mu.Unlock()
-
-	if seq > px.dones[px.me] {
-		px.dones[px.me] = seq
+func (px *Paxos) StartWithPNum(seq int, pnum string, v interface{}) {
+	if _, _, ok := splitPNum(pnum); !ok {
+		px.logf("Paxos(%v) StartWithPNum(%v): malformed pnum %q", px.me, seq, pnum)
+		return
 	}
+	px.startOrQueue(seq, pnum, v)
 }
 
-//
-// the application wants to know the
-// highest instance sequence known to
-// this peer.
-//
-func (px *Paxos) Max() int {
-	// Your code here.
-	max := 0
-	for i, _ := range px.instances {
-		if i > max {
-			max = i
-		}
+// if result isn't nil, the proposer sends what propose()
+// returned on it. with Config.MaxProposers, it waits for a
+// free slot until wait is closed, or not at all if wait is
+// nil.
+func (px *Paxos) start(seq int, pnum string, v interface{}, result chan<- error, wait <-chan struct{}) error {
+	if seq < px.Min() {
+		return ErrForgotten
 	}
-	return max
-}
-
-//
+	// nothing to do, so don't spend a goroutine and a round
+	// of Prepares finding that out.
+	if fate, _ := px.Status(seq); fate == Decided {
+		return nil
+	}
+	if px.following() {
+		px.logf("Paxos(%v) refusing to start instance %v: %v", px.me, seq, ErrNotLeader)
+		return ErrNotLeader
+	}
+	if err := px.checkLimit(seq); err != nil {
+		px.logf("Paxos(%v) refusing to start instance %v: %v", px.me, seq, err)
+		return err
+	}
+	if err := px.checkValue(v); err != nil {
+		px.logf("Paxos(%v) refusing to start instance %v: %v", px.me, seq, err)
+		return err
+	}
+	if !px.acquireProposer(wait) {
+		return ErrBusy
+	}
+	px.launch(seq, pnum, v, result)
+	return nil
+}
+
+// run a proposer for seq in a slot already taken, sending
+// what propose() returned on result if it isn't nil.
+func (px *Paxos) launch(seq int, pnum string, v interface{}, result chan<- error) {
+	// registered before start() returns, so that a
+	// CancelProposal() right after it finds the proposer.
+	cancel := px.registerProposal(seq)
+	go func() {
+		err := px.propose(seq, v, pnum, cancel)
+		px.unregisterProposal(seq, cancel)
+		px.releaseProposer()
+		if result != nil {
+			result <- err
+		}
+	} ()
+}
+
+// is v small enough for Config.MaxValueBytes?
+func (px *Paxos) checkValue(v interface{}) error {
+	if px.config.MaxValueBytes <= 0 {
+		return nil
+	}
+	enc, err := encodeValue(v)
+	if err != nil {
+		return fmt.Errorf("paxos: can't encode value: %v", err)
+	}
+	if len(enc) > px.config.MaxValueBytes {
+		return fmt.Errorf("%w: %v bytes, limit %v", ErrValueTooLarge, len(enc), px.config.MaxValueBytes)
+	}
+	return nil
+}
+
+// the Config.MaxInstances safety valve: once that many
+// instances are live, refuse to start any above them.
+func (px *Paxos) checkLimit(seq int) error {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	return px.limitLocked(seq)
+}
+
+// checkLimit(), for callers that hold px.mu.
+func (px *Paxos) limitLocked(seq int) error {
+	if px.config.MaxInstances <= 0 {
+		return nil
+	}
+	if len(px.instances) < px.config.MaxInstances {
+		return nil
+	}
+	for s := range px.instances {
+		if seq <= s {
+			return nil
+		}
+	}
+	return ErrTooManyInstances
+}
+
+//
+// start agreement on instance seq and wait until it is
+// decided, returning the decided value. that may not be
+// v if another peer's proposal won. returns ErrForgotten
+// if seq is (or becomes) older than Min(), an error if the
+// instance can't be started, ErrNotLeader if another peer
+// holds the leader lease, ErrMaxRounds or
+// ErrPNumExhausted if the proposer gives up, or the
+// context's error if ctx is done first.
+//
+func (px *Paxos) Propose(ctx context.Context, seq int, v interface{}) (interface{}, error) {
+	result := make(chan error, 1)
+	if err := px.start(seq, "", v, result, ctx.Done()); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return px.waitDecided(ctx, seq, result)
+}
+
+//
+// propose v in the instance after the highest one this
+// peer knows of, moving on to the next whenever another
+// value wins, until v is decided somewhere. with
+// Config.SeqAllocator, that says which instances instead.
+// returns the seq v landed in, and the value decided
+// there. a value that's equal to v (see Config.ValueEqual)
+// counts as v, so appenders that need to tell equal values
+// apart should wrap them with something unique, as Log
+// does.
+//
+func (px *Paxos) Append(ctx context.Context, v interface{}) (int, interface{}, error) {
+	seq := -1
+	for {
+		seq = px.nextAppendSeq(seq)
+		decided, err := px.Propose(ctx, seq, v)
+		if err == nil && px.valueEqual(decided, v) {
+			return seq, decided, nil
+		}
+		if err != nil && err != ErrForgotten {
+			return -1, nil, err
+		}
+		atomic.AddInt64(&px.collisions, 1)
+	}
+}
+
+// one past the highest instance this peer has state for,
+// and at least Min(). caller must hold px.mu.
+func (px *Paxos) nextSeqLocked() int {
+	next := px.minLocked()
+	for seq := range px.instances {
+		if seq >= next {
+			next = seq + 1
+		}
+	}
+	for seq := range px.spilled {
+		if seq >= next {
+			next = seq + 1
+		}
+	}
+	return next
+}
+
+// block until seq is decided locally. if the proposer
+// reports an error on result first, return that.
+func (px *Paxos) waitDecided(ctx context.Context, seq int, result <-chan error) (interface{}, error) {
+	for {
+		px.mu.Lock()
+		if seq < px.minLocked() {
+			px.mu.Unlock()
+			return nil, ErrForgotten
+		}
+		if instance, ok := px.lookupLocked(seq); ok && instance.state == Decided {
+			v := instance.v_a
+			px.mu.Unlock()
+			return v, nil
+		}
+		ch := make(chan struct{})
+		px.waiters[seq] = append(px.waiters[seq], ch)
+		px.mu.Unlock()
+
+		select {
+		case <-ch:
+		case err := <-result:
+			if err != nil {
+				px.dropWaiter(seq, ch)
+				return nil, err
+			}
+			result = nil
+			px.dropWaiter(seq, ch)
+		case <-ctx.Done():
+			px.dropWaiter(seq, ch)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// stop waiting on seq with ch, which hasn't been closed,
+// so that px.waiters doesn't keep it until seq is decided.
+func (px *Paxos) dropWaiter(seq int, ch chan struct{}) {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	waiters := px.waiters[seq]
+	for i, w := range waiters {
+		if w == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(px.waiters, seq)
+	} else {
+		px.waiters[seq] = waiters
+	}
+}
+
+// wake up everyone waiting on seq. caller must hold px.mu.
+func (px *Paxos) notifyLocked(seq int) {
+	for _, ch := range px.waiters[seq] {
+		close(ch)
+	}
+	delete(px.waiters, seq)
+}
+
+//
+// the application on this machine is done with
+// all instances <= seq.
+//
+// Done only ever moves forward: a seq at or below an
+// earlier one is ignored, so concurrent callers can't
+// undo each other. MyDone() says where it got to.
+//
+// see the comments for Min() for more explanation.
+//
+func (px *Paxos) Done(seq int) {
+	// Your code here.
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	if seq > px.dones[px.me] {
+		px.dones[px.me] = seq
+		px.saveDoneLocked()
+		px.advanceMinLocked()
+		px.spillLocked()
+	}
+}
+
+// the highest seq passed to Done() on this peer, or -1.
+func (px *Paxos) MyDone() int {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	return px.dones[px.me]
+}
+
+//
+// the highest Done() argument this peer has heard
+// from each peer, indexed like peers[]. -1 means
+// nothing heard yet. handy for finding out which
+// peer is holding Min() back.
+//
+func (px *Paxos) DoneVector() []int {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	dones := make([]int, len(px.dones))
+	copy(dones, px.dones)
+	return dones
+}
+
+// how many peers there are, including this one.
+func (px *Paxos) NPeers() int {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	return len(px.peers)
+}
+
+// this peer's index into Peers().
+func (px *Paxos) Me() int {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	return px.me
+}
+
+// a copy of the peers' addresses, as passed to Make().
+func (px *Paxos) Peers() []string {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	peers := make([]string, len(px.peers))
+	copy(peers, px.peers)
+	return peers
+}
+
+//
+// the application wants to know the
+// highest instance sequence known to
+// this peer.
+//
+// that's the highest seq this peer has ever had state for,
+// even if it has since been forgotten, so Max() never goes
+// down. -1 if this peer has never heard of any.
+//
+func (px *Paxos) Max() int {
+	// Your code here.
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	return px.maxSeen
+}
+
+// the state for instance seq, created if there's none yet:
+// the one place instances are born. refuses to create one
+// past the Config.MaxInstances guard, so a peer that's full
+// rejects Prepares, and drops Decides, above its window
+// until it forgets some; and to stand a blank one in for a
+// spilled instance it can't read back. caller must hold
+// px.mu.
+func (px *Paxos) getOrCreateLocked(seq int) (*instance, error) {
+	return px.instanceLocked(seq, true)
+}
+
+// getOrCreateLocked(), checking Config.MaxInstances only if
+// limit is set. state that's being restored rather than
+// agreed on, from the WAL or InstallDecided(), skips it.
+func (px *Paxos) instanceLocked(seq int, limit bool) (*instance, error) {
+	if instance, ok := px.instances[seq]; ok {
+		return instance, nil
+	}
+	instance, err := px.unspillLocked(seq)
+	if err != nil {
+		return nil, err
+	}
+	if instance != nil {
+		delete(px.spilled, seq)
+		px.saveSpilledLocked()
+		if err := px.config.Persister.Save(spillKey(seq), nil); err != nil {
+			px.logf("Paxos(%v) can't drop spilled instance %v: %v", px.me, seq, err)
+		}
+		px.instances[seq] = instance
+		return instance, nil
+	}
+	if limit {
+		if err := px.limitLocked(seq); err != nil {
+			return nil, err
+		}
+	}
+	instance = px.newInstance()
+	px.instances[seq] = instance
+	if seq > px.maxSeen {
+		px.maxSeen = seq
+	}
+	return instance, nil
+}
+
+//
 // Min() should return one more than the minimum among z_i,
 // where z_i is the highest number ever passed
 // to Done() on peer i. A peers z_i is -1 if it has
@@ -449,22 +1471,141 @@ func (px *Paxos) Min() int {
 	px.mu.Lock()
 	defer px.mu.Unlock()
 
-	min := px.dones[px.me]
-	for _, i := range px.dones {
-		if i < min {
-			min = i
+	return px.minLocked()
+}
+
+//
+// forget every instance below seq at once, decided or not,
+// and whatever the other peers' Done()s say, as if Min()
+// had reached seq. for relieving memory pressure when a
+// dead peer is holding Min() down and can't be configured
+// out yet.
+//
+// this is a last resort. a peer that's behind can no longer
+// learn anything below seq from this one, and if every peer
+// that knows a decision below seq forgets it, it's gone for
+// good. Min() never goes back down, so it can't be undone.
+//
+func (px *Paxos) ForceForget(seq int) {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	if seq > px.minFloor {
+		px.minFloor = seq
+	}
+	for s := range px.instances {
+		if s < seq {
+			delete(px.instances, s)
 		}
 	}
+	px.minLocked()
+}
+
+// compute Min() and forget decided instances below it.
+// caller must hold px.mu.
+func (px *Paxos) minLocked() int {
+	min := px.advanceMinLocked()
 
 	for seq, instance := range px.instances {
 		if seq <= min && instance.state == Decided {
 			delete(px.instances, seq)
 		}
 	}
+	px.forgetSpilledLocked(min)
+	// nobody will ever decide these for us now
+	for seq := range px.waiters {
+		if seq <= min {
+			px.notifyLocked(seq)
+		}
+	}
 
 	return min+1
 }
 
+//
+// the lowest Done() heard from any peer, except that once
+// Min() has advanced it stays there, even if a peer turns
+// up with a lower Done (e.g. one that's new, or restarted
+// without its state): what's forgotten stays forgotten.
+// instances at or below it are forgotten. changes nothing;
+// advanceMinLocked() records where it's got to. caller must
+// hold px.mu.
+//
+func (px *Paxos) lowestDoneLocked() int {
+	min := px.dones[px.me]
+	for _, i := range px.dones {
+		if i < min {
+			min = i
+		}
+	}
+	if min < px.minFloor-1 {
+		min = px.minFloor - 1
+	}
+	return min
+}
+
+// after a Done() has changed, raise the floor Min() never
+// goes below, and minCache, telling Config.OnMinAdvance if
+// Min() moved. returns lowestDoneLocked(). caller must hold
+// px.mu.
+func (px *Paxos) advanceMinLocked() int {
+	min := px.lowestDoneLocked()
+	px.minFloor = min + 1
+	if int64(min+1) > atomic.LoadInt64(&px.minCache) {
+		atomic.StoreInt64(&px.minCache, int64(min+1))
+		px.pokeMinNotifier()
+	}
+	return min
+}
+
+// move instance seq to a new state, creating it if need
+// be. refuses, and logs, transitions that would rewrite
+// history: a Decided instance stays Decided and a Forgotten
+// one stays forgotten. (forgetting itself is minLocked()'s
+// job.) caller must hold px.mu.
+func (px *Paxos) setState(seq int, state Fate) bool {
+	from := Pending
+	instance, exist := px.instances[seq]
+	if seq <= px.lowestDoneLocked() {
+		from = Forgotten
+	} else if exist {
+		from = instance.state
+	} else if px.spilled[seq] {
+		// bring it back, so the transition is checked
+		// against the state it was spilled in.
+		var err error
+		if instance, err = px.getOrCreateLocked(seq); err != nil {
+			px.logf("Paxos(%v) instance %v: %v", px.me, seq, err)
+			return false
+		}
+		exist = true
+		from = instance.state
+	}
+
+	legal := false
+	switch from {
+	case Pending:
+		legal = state == Pending || state == Decided
+	case Decided:
+		legal = state == Decided
+	}
+	if !legal {
+		px.logf("Paxos(%v) instance %v: refusing illegal transition %v -> %v",
+			px.me, seq, from, state)
+		return false
+	}
+
+	if !exist {
+		var err error
+		if instance, err = px.getOrCreateLocked(seq); err != nil {
+			px.logf("Paxos(%v) instance %v: %v", px.me, seq, err)
+			return false
+		}
+	}
+	instance.state = state
+	return true
+}
+
 //
 // the application wants to know whether this
 // peer thinks an instance has been decided,
@@ -473,12 +1614,30 @@ func (px *Paxos) Min() int {
 // it should not contact other Paxos peers.
 //
 func (px *Paxos) Status(seq int) (Fate, interface{}) {
+	fate, v := px.rawStatus(seq)
+	if px.config.HideNoOps && isNoOp(v) {
+		return fate, nil
+	}
+	return fate, v
+}
+
+// Status(), NoOps and all.
+func (px *Paxos) rawStatus(seq int) (Fate, interface{}) {
 	// Your code here.
-	if seq < px.Min() {
+	// Min() as cached when it last moved, rather than
+	// Min() itself, which would also garbage-collect.
+	if int64(seq) < atomic.LoadInt64(&px.minCache) {
 		return Forgotten, nil
 	}
-	instance, exist := px.instances[seq]
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	instance, exist := px.lookupLocked(seq)
 	if !exist {
+		// Min() may have moved past seq, and forgotten it,
+		// since minCache was read; it only moves under px.mu.
+		if int64(seq) < atomic.LoadInt64(&px.minCache) {
+			return Forgotten, nil
+		}
 		return Pending, nil
 	} else {
 		return instance.state, instance.v_a
@@ -486,7 +1645,231 @@ func (px *Paxos) Status(seq int) (Fate, interface{}) {
 	return Pending, nil
 }
 
+//
+// like Status(), but also says whether this peer has any
+// state for instance seq, which tells an instance it has
+// never heard of apart from one that is Pending because
+// it is being decided. forgotten instances have no state.
+//
+func (px *Paxos) StatusEx(seq int) (Fate, bool, interface{}) {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	if seq < px.minLocked() {
+		return Forgotten, false, nil
+	}
+	instance, exist := px.lookupLocked(seq)
+	if !exist {
+		return Pending, false, nil
+	}
+	return instance.state, true, instance.v_a
+}
+
+
+
+//
+// ask every peer for its view of instance seq and check
+// that all peers that have decided it agree on the value.
+// peers that haven't decided (or can't be reached) are
+// skipped. false means two peers decided different values,
+// which is a safety bug. returns ErrNoMajority if too few
+// peers answered for the check to mean anything.
+//
+func (px *Paxos) VerifyConsensus(seq int) (bool, error) {
+	args := QueryArgs{Seq: seq, GroupID: px.group}
+	responded := 0
+	var decided []interface{}
+	for i := range px.peers {
+		var reply QueryReply
+		ok := px.invoke(i, "Paxos.Query", &args, &reply)
+		if !ok || reply.Err != OK {
+			continue
+		}
+		responded++
+		if reply.State == Decided {
+			decided = append(decided, reply.Value)
+		}
+	}
+
+	for i := 1; i < len(decided); i++ {
+		if !px.valueEqual(decided[0], decided[i]) {
+			return false, nil
+		}
+	}
+	if responded < px.majority() {
+		return false, ErrNoMajority
+	}
+	return true, nil
+}
+
+//
+// like Status(), but instead of trusting this peer's
+// possibly stale view, ask all peers and report seq as
+// Decided only if a majority of them have decided the
+// same value. otherwise seq is Pending. peers that
+// disagree with the majority are ignored. returns
+// ErrNoMajority if a majority can't be reached, or the
+// context's error if ctx is done first.
+//
+func (px *Paxos) ConsistentStatus(ctx context.Context, seq int) (Fate, interface{}, error) {
+	if seq < px.Min() {
+		return Forgotten, nil, nil
+	}
+
+	args := QueryArgs{Seq: seq, GroupID: px.group}
+	replies := make(chan *QueryReply, len(px.peers))
+	for i := range px.peers {
+		go func(i int) {
+			reply := &QueryReply{}
+			ok := px.invoke(i, "Paxos.Query", &args, reply)
+			if !ok || reply.Err != OK {
+				reply = nil
+			}
+			replies <- reply
+		}(i)
+	}
+
+	responded := 0
+	var values []interface{} // one per Decided reply
+	for n := 0; n < len(px.peers); n++ {
+		var reply *QueryReply
+		select {
+		case reply = <-replies:
+		case <-ctx.Done():
+			return Pending, nil, ctx.Err()
+		}
+		if reply == nil {
+			continue
+		}
+		responded++
+		if reply.State != Decided {
+			continue
+		}
+		values = append(values, reply.Value)
+		agree := 0
+		for _, v := range values {
+			if px.valueEqual(v, reply.Value) {
+				agree++
+			}
+		}
+		if agree >= px.majority() {
+			return Decided, reply.Value, nil
+		}
+	}
+
+	if responded < px.majority() {
+		return Pending, nil, ErrNoMajority
+	}
+	return Pending, nil, nil
+}
+
+//
+// can a proposal from this peer get anywhere? asks every
+// peer, in parallel, and returns true as soon as a majority
+// (this peer included) have answered, or false if ctx is
+// done first or too many can't be reached. the answer may
+// be out of date by the time the caller acts on it, but
+// false means a proposal now would just spin.
+//
+func (px *Paxos) CanMakeProgress(ctx context.Context) bool {
+	// any handler will do as a ping; Query changes nothing.
+	args := QueryArgs{Seq: -1, GroupID: px.group}
+	replies := make(chan bool, len(px.peers))
+	for i := range px.peers {
+		go func(i int) {
+			var reply QueryReply
+			replies <- px.invoke(i, "Paxos.Query", &args, &reply) && reply.Err == OK
+		}(i)
+	}
+
+	responded := 0
+	for n := 0; n < len(px.peers); n++ {
+		select {
+		case ok := <-replies:
+			if ok {
+				responded++
+			}
+		case <-ctx.Done():
+			return false
+		}
+		if responded >= px.majority() {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Status(), for callers that only trust a decision this
+// peer learned within maxAge. ok is false if seq isn't
+// decided here, or was decided longer ago than that, in
+// which case the caller should fall back to a consistent
+// read such as ConsistentStatus(). Forgotten is always ok.
+//
+func (px *Paxos) StaleStatus(seq int, maxAge time.Duration) (Fate, interface{}, bool) {
+	px.mu.Lock()
+	defer px.mu.Unlock()
 
+	if seq < px.minLocked() {
+		return Forgotten, nil, true
+	}
+	instance, exist := px.lookupLocked(seq)
+	if !exist {
+		return Pending, nil, false
+	}
+	if instance.state != Decided {
+		return instance.state, nil, false
+	}
+	fresh := px.clock.Now().Sub(instance.decidedAt) <= maxAge
+	return Decided, instance.v_a, fresh
+}
+
+//
+// like Start(), but first ask the other peers whether seq
+// is already decided, and don't propose if it is. returns
+// false, having learned the decision if a peer still had
+// it, if seq was decided (or forgotten) anywhere; true if
+// it started proposing v, or queued it behind
+// Config.MaxProposers.
+//
+func (px *Paxos) StartIfPending(seq int, v interface{}) bool {
+	if fate, _ := px.Status(seq); fate != Pending {
+		return false
+	}
+
+	args := QueryArgs{Seq: seq, GroupID: px.group}
+	replies := make(chan *QueryReply, len(px.peers))
+	for i := range px.peers {
+		if i == px.me {
+			continue
+		}
+		go func(i int) {
+			reply := &QueryReply{}
+			if !px.invoke(i, "Paxos.Query", &args, reply) || reply.Err != OK {
+				reply = nil
+			}
+			replies <- reply
+		}(i)
+	}
+
+	decided := false
+	for n := 0; n < len(px.peers)-1; n++ {
+		reply := <-replies
+		if reply == nil || reply.State == Pending {
+			continue
+		}
+		decided = true
+		if reply.State == Decided {
+			px.mu.Lock()
+			px.decideLocked(seq, reply.PNum, reply.Value)
+			px.mu.Unlock()
+		}
+	}
+	if decided {
+		return false
+	}
+	return px.startOrQueue(seq, "", v) == nil
+}
 
 //
 // tell the peer to shut itself down.
@@ -520,75 +1903,293 @@ func (px *Paxos) isunreliable() bool {
 	return atomic.LoadInt32(&px.unreliable) != 0
 }
 
+//
+// forget every instance and every peer's Done, as if this
+// peer had just been made, but keep its listener, so a test
+// can reuse it without leaking sockets. the other peers
+// must be reset too, or they'll remember what this one
+// doesn't. for testing only: it's unsafe while agreement
+// is under way.
+//
+func (px *Paxos) Reset() {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	px.instances = map[int]*instance{}
+	if px.spilled != nil {
+		px.spilled = nil
+		px.saveSpilledLocked()
+	}
+	for i := range px.dones {
+		px.dones[i] = -1
+	}
+	px.minFloor = 0
+	atomic.StoreInt64(&px.minCache, 0)
+	px.maxSeen = -1
+	px.wins = map[int]int{}
+	for i := range px.resend {
+		if px.resend[i] != nil {
+			// its retryDecides() finds nothing left, and ends.
+			px.resend[i] = map[int]DecideArgs{}
+		}
+	}
+	px.leaseHolder = -1
+	px.leaseExpiry = time.Time{}
+	px.leaseUntil = time.Time{}
+	px.leaseBarrier = -1
+	px.uniques = map[string]uniqueEntry{}
+	px.uniqueKeys = nil
+	atomic.StoreInt32(&px.rpcCount, 0)
+
+	px.latMu.Lock()
+	px.latencies = nil
+	px.latMu.Unlock()
+}
+
+//
+// take this peer out of voting, e.g. for maintenance,
+// without shutting it down: until Resume(), it rejects
+// every Prepare and Accept, so it counts towards no
+// quorum, but it still learns decisions. the others
+// carry on as long as a majority of them aren't paused.
+//
+func (px *Paxos) Pause() {
+	atomic.StoreInt32(&px.paused, 1)
+}
+
+//
+// let a paused peer vote again.
+//
+func (px *Paxos) Resume() {
+	atomic.StoreInt32(&px.paused, 0)
+}
+
+func (px *Paxos) ispaused() bool {
+	return atomic.LoadInt32(&px.paused) != 0
+}
+
+//
+// accept RPC connections on px.l until the peer is killed.
+// temporary accept errors (e.g. "too many open files") back
+// off before retrying so a degraded listener doesn't spin;
+// any other error stops the loop.
+//
+func (px *Paxos) serve(rpcs *rpc.Server) {
+	var tempDelay time.Duration // how long to sleep on accept failure
+	for px.isdead() == false {
+		conn, err := px.l.Accept()
+		if err == nil && px.isdead() == false {
+			tempDelay = 0
+			unreliable := px.isunreliable() && !px.config.DisableUnreliable
+			if px.isProbe(conn) {
+				// Make()'s check that it's reachable: not an
+				// RPC, so not counted. see selfcheck.go.
+				conn.Close()
+				select {
+				case <-px.probed:
+				default:
+					close(px.probed)
+				}
+			} else if unreliable && (rand.Int63()%1000) < 100 {
+				// discard the request.
+				conn.Close()
+			} else if unreliable && (rand.Int63()%1000) < 200 {
+				// process the request but force discard of reply.
+				c1 := conn.(*net.UnixConn)
+				f, _ := c1.File()
+				err := syscall.Shutdown(int(f.Fd()), syscall.SHUT_WR)
+				if err != nil {
+					fmt.Printf("shutdown: %v\n", err)
+				}
+				atomic.AddInt32(&px.rpcCount, 1)
+				go px.serveConn(rpcs, conn)
+			} else {
+				atomic.AddInt32(&px.rpcCount, 1)
+				go px.serveConn(rpcs, conn)
+			}
+		} else if err == nil {
+			conn.Close()
+		}
+		if err != nil && px.isdead() == false {
+			fmt.Printf("Paxos(%v) accept: %v\n", px.me, err.Error())
+			if !acceptBackoff(px.clock, err, &tempDelay) {
+				return
+			}
+		}
+	}
+}
+
+// sleep after a failed Accept() if the error is temporary,
+// doubling *delay each time. returns false if the error is
+// fatal and the accept loop should stop.
+func acceptBackoff(clock Clock, err error, delay *time.Duration) bool {
+	ne, ok := err.(net.Error)
+	if !ok || !ne.Temporary() {
+		return false
+	}
+	if *delay == 0 {
+		*delay = 5 * time.Millisecond
+	} else {
+		*delay *= 2
+	}
+	if *delay > time.Second {
+		*delay = time.Second
+	}
+	<-clock.After(*delay)
+	return true
+}
+
 //
 // the application wants to create a paxos peer.
 // the ports of all the paxos peers (including this one)
 // are in peers[]. this servers port is peers[me].
 //
 func Make(peers []string, me int, rpcs *rpc.Server) *Paxos {
+	px, err := MakeWithConfig(peers, me, rpcs, Config{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return px
+}
+
+//
+// like Make(), but serve RPCs on l, which the caller has
+// already set up, e.g. by socket activation, rather than
+// listening on peers[me] itself. l must be where the other
+// peers reach peers[me]. rpcs may be nil, as for Make(). the
+// peer closes l when it's killed.
+//
+func MakeWithListener(peers []string, me int, l net.Listener, rpcs *rpc.Server) (*Paxos, error) {
+	return MakeWithConfig(peers, me, rpcs, Config{Listener: l})
+}
+
+//
+// like Make(), but with optional settings, and returning
+// an error rather than exiting if the peer can't be set up.
+//
+func MakeWithConfig(peers []string, me int, rpcs *rpc.Server, cfg Config) (*Paxos, error) {
+	// a single peer is fine, and agrees with itself without
+	// any RPCs, but there's no majority of nobody.
+	if len(peers) == 0 {
+		return nil, ErrNoPeers
+	}
+	if me < 0 || me >= len(peers) {
+		return nil, fmt.Errorf("paxos: me %v out of range for %v peers", me, len(peers))
+	}
+	// two entries for one process would give it two votes,
+	// and a majority of them could be a minority of processes.
+	seen := map[string]int{}
+	for i, addr := range peers {
+		if j, ok := seen[addr]; ok {
+			return nil, fmt.Errorf("paxos: peers %v and %v are both %v", j, i, addr)
+		}
+		seen[addr] = i
+	}
+
 	px := &Paxos{}
 	px.peers = peers
 	px.me = me
+	px.config = cfg
+	px.clock = cfg.Clock
+	if px.clock == nil {
+		px.clock = realClock{}
+	}
+	px.seedBackoff(cfg.BackoffSeed)
+	if cfg.MaxProposers > 0 {
+		px.proposers = make(chan struct{}, cfg.MaxProposers)
+	}
+	if cfg.OnMinAdvance != nil {
+		px.minAdvanced = make(chan struct{}, 1)
+	}
 
 
 	// Your initialization code here.
 	px.instances = map[int]*instance{}
+	px.probed = make(chan struct{})
+	px.waiters = map[int][]chan struct{}{}
+	px.maxSeen = -1
+	px.wins = map[int]int{}
+	px.resend = make([]map[int]DecideArgs, len(px.peers))
+	px.leaseHolder = -1
+	px.leaseBarrier = -1
+	px.uniques = map[string]uniqueEntry{}
 	px.dones = make([]int, len(px.peers))
 	for i := range px.peers {
 		px.dones[i] = -1
 	}
+	if err := px.loadDone(); err != nil {
+		return nil, err
+	}
+	if err := px.loadSpilled(); err != nil {
+		return nil, err
+	}
+	px.replayWAL()
 
-	if rpcs != nil {
-		// caller will create socket &c
-		rpcs.Register(px)
+	if cfg.Transport != nil {
+		px.transport = cfg.Transport(px)
+		if err := px.transport.Listen(peers[me]); err != nil {
+			return nil, err
+		}
+		go px.transport.Serve()
+	} else if rpcs != nil && cfg.Listener == nil {
+		// caller will create socket &c. a server shared with
+		// another peer, or a Mux, already has a "Paxos".
+		if err := rpcs.Register(px); err != nil {
+			return nil, fmt.Errorf("paxos: can't register with the RPC server: %v", err)
+		}
+		px.transport = &rpcTransport{px: px, rpcs: rpcs}
 	} else {
-		rpcs = rpc.NewServer()
-		rpcs.Register(px)
-
-		// prepare to receive connections from clients.
-		// change "unix" to "tcp" to use over a network.
-		os.Remove(peers[me]) // only needed for "unix"
-		l, e := net.Listen("unix", peers[me])
-		if e != nil {
-			log.Fatal("listen error: ", e)
+		if rpcs == nil {
+			rpcs = rpc.NewServer()
+		}
+		if err := rpcs.Register(px); err != nil {
+			return nil, fmt.Errorf("paxos: can't register with the RPC server: %v", err)
+		}
+		px.transport = &rpcTransport{px: px, rpcs: rpcs}
+
+		// prepare to receive connections from clients,
+		// unless the caller already has.
+		if cfg.Listener != nil {
+			px.l = cfg.Listener
+		} else if err := px.transport.Listen(peers[me]); err != nil {
+			return nil, err
 		}
-		px.l = l
 
 		// please do not change any of the following code,
 		// or do anything to subvert it.
 
 		// create a thread to accept RPC connections
-		go func() {
-			for px.isdead() == false {
-				conn, err := px.l.Accept()
-				if err == nil && px.isdead() == false {
-					if px.isunreliable() && (rand.Int63()%1000) < 100 {
-						// discard the request.
-						conn.Close()
-					} else if px.isunreliable() && (rand.Int63()%1000) < 200 {
-						// process the request but force discard of reply.
-						c1 := conn.(*net.UnixConn)
-						f, _ := c1.File()
-						err := syscall.Shutdown(int(f.Fd()), syscall.SHUT_WR)
-						if err != nil {
-							fmt.Printf("shutdown: %v\n", err)
-						}
-						atomic.AddInt32(&px.rpcCount, 1)
-						go rpcs.ServeConn(conn)
-					} else {
-						atomic.AddInt32(&px.rpcCount, 1)
-						go rpcs.ServeConn(conn)
-					}
-				} else if err == nil {
-					conn.Close()
-				}
-				if err != nil && px.isdead() == false {
-					fmt.Printf("Paxos(%v) accept: %v\n", me, err.Error())
-				}
+		go px.transport.Serve()
+	}
+
+	go px.closeWhenDead()
+
+	if px.l != nil && cfg.Transport == nil {
+		if err := px.checkSelfDial(); err != nil {
+			if cfg.RequireSelfDial {
+				px.Kill()
+				return nil, err
 			}
-		}()
+			px.logf("%v", err)
+		}
+	}
+
+	if cfg.LeaseDuration > 0 {
+		go px.keepLeader()
+	}
+	if cfg.Persister != nil && cfg.Durability == DurabilityBuffered {
+		go px.flusher()
+	}
+	if cfg.ExchangeDoneInterval > 0 {
+		go px.exchangeDones(cfg.ExchangeDoneInterval)
+	}
+	if cfg.CompleteAfter > 0 {
+		go px.completer(cfg.CompleteAfter)
+	}
+	if cfg.OnMinAdvance != nil {
+		go px.minNotifier()
 	}
 
 
-	return px
+	return px, nil
 }
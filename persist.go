@@ -0,0 +1,178 @@
+package paxos
+
+//
+// state that survives a restart.
+//
+// for now that's just this peer's own Done(). without it, a
+// restarted peer would be back at -1 and pin Min() to 0,
+// asking the others for instances they've already
+// forgotten. the other peers' Done values needn't be kept:
+// they ride along on every Decide, so a restarted peer
+// learns them again as soon as agreement resumes. the
+// instances themselves go in a write-ahead log instead, if
+// Config.WAL is set; see wal.go.
+//
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+//
+// a small key/value store for Paxos's durable state.
+// Load returns nil, nil for a key that was never saved.
+//
+type Persister interface {
+	Save(key string, data []byte) error
+	Load(key string) ([]byte, error)
+}
+
+//
+// a Persister keeping one file per key in a directory,
+// replacing each file atomically on Save.
+//
+type FilePersister struct {
+	Dir string
+}
+
+func (fp *FilePersister) Save(key string, data []byte) error {
+	if err := os.MkdirAll(fp.Dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(fp.Dir, key+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(fp.Dir, key))
+}
+
+func (fp *FilePersister) Load(key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(fp.Dir, key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+//
+// how hard Paxos tries to get its state to the Persister
+// before going on. it doesn't apply to Config.WAL: a
+// promise that isn't durable before the reply could be
+// broken by a crash, so the WAL is always synced, and
+// an application that can do without that can do without
+// the WAL.
+//
+type Durability int
+
+const (
+	// save before going on (the Persister's Save must be
+	// durable when it returns, as FilePersister's is). the
+	// safest, and the slowest if saves are frequent.
+	DurabilitySync Durability = iota
+
+	// keep changes in memory and save the latest of them
+	// every Config.FlushInterval. a crash loses whatever
+	// changed since the last flush, so a restarted peer can
+	// come back with older state than it had told others
+	// about. only for applications that can live with that.
+	DurabilityBuffered
+
+	// never save or load, as if there were no Persister.
+	DurabilityNone
+)
+
+// how often DurabilityBuffered flushes, unless
+// Config.FlushInterval says otherwise.
+const defaultFlushInterval = 100 * time.Millisecond
+
+// the key this peer's own Done() is kept under.
+const doneKey = "done"
+
+// caller must hold px.mu.
+func (px *Paxos) saveDoneLocked() {
+	px.persistLocked(doneKey, []byte(strconv.Itoa(px.dones[px.me])))
+}
+
+// save data under key, as Config.Durability says.
+// caller must hold px.mu.
+func (px *Paxos) persistLocked(key string, data []byte) {
+	if px.config.Persister == nil {
+		return
+	}
+	switch px.config.Durability {
+	case DurabilitySync:
+		if err := px.config.Persister.Save(key, data); err != nil {
+			px.logf("Paxos(%v) can't save %v: %v", px.me, key, err)
+		}
+	case DurabilityBuffered:
+		if px.unflushed == nil {
+			px.unflushed = map[string][]byte{}
+		}
+		px.unflushed[key] = data
+	}
+}
+
+// save buffered changes every FlushInterval until killed.
+func (px *Paxos) flusher() {
+	interval := px.config.FlushInterval
+	if interval <= 0 {
+		interval = defaultFlushInterval
+	}
+	for px.isdead() == false {
+		<-px.clock.After(interval)
+		if px.isdead() {
+			// crashed; whatever wasn't flushed is lost.
+			return
+		}
+		px.flush()
+	}
+}
+
+// save whatever has changed since the last flush.
+func (px *Paxos) flush() {
+	px.mu.Lock()
+	unflushed := px.unflushed
+	px.unflushed = nil
+	px.mu.Unlock()
+
+	for key, data := range unflushed {
+		if err := px.config.Persister.Save(key, data); err != nil {
+			px.logf("Paxos(%v) can't save %v: %v", px.me, key, err)
+		}
+	}
+}
+
+// restore this peer's own Done() from before a restart.
+func (px *Paxos) loadDone() error {
+	if px.config.Persister == nil || px.config.Durability == DurabilityNone {
+		return nil
+	}
+	data, err := px.config.Persister.Load(doneKey)
+	if err != nil || data == nil {
+		return err
+	}
+	done, err := strconv.Atoi(string(data))
+	if err != nil {
+		return fmt.Errorf("paxos: bad saved Done %q: %v", data, err)
+	}
+	px.dones[px.me] = done
+	return nil
+}
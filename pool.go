@@ -0,0 +1,167 @@
+package paxos
+
+//
+// reusing connections between peers, for Config.ReuseConns.
+//
+// call() dials a new connection for every RPC. with ReuseConns
+// a peer instead keeps one *rpc.Client per address, shared by
+// all its concurrent calls (net/rpc multiplexes them), and
+// throws it away when the connection breaks, dialing a new one
+// on the next call.
+//
+// a killed peer stops answering on the connections it's
+// serving, and soon closes them, so that clients pooled by
+// other peers notice it's gone rather than talking to its
+// corpse, and reconnect to it once it's back.
+//
+
+import (
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+)
+
+type connPool struct {
+	mu      sync.Mutex
+	clients map[string]*rpc.Client
+	dials   int64 // how many connections have been pooled
+}
+
+// the pooled client for srv, dialing one if there isn't one.
+// the dial happens without p.mu, so that a peer that's slow
+// to answer doesn't hold up calls to the others.
+func (p *connPool) get(srv string) (*rpc.Client, error) {
+	p.mu.Lock()
+	c, ok := p.clients[srv]
+	p.mu.Unlock()
+	if ok {
+		return c, nil
+	}
+	c, err := rpc.Dial("unix", srv)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if other, ok := p.clients[srv]; ok {
+		// another call dialed srv meanwhile; use its client.
+		c.Close()
+		return other, nil
+	}
+	atomic.AddInt64(&p.dials, 1)
+	if p.clients == nil {
+		p.clients = map[string]*rpc.Client{}
+	}
+	p.clients[srv] = c
+	return c, nil
+}
+
+// forget c, if it's still srv's client, and close it.
+func (p *connPool) drop(srv string, c *rpc.Client) {
+	p.mu.Lock()
+	if p.clients[srv] == c {
+		delete(p.clients, srv)
+	}
+	p.mu.Unlock()
+	c.Close()
+}
+
+// close every pooled client.
+func (p *connPool) close() {
+	p.mu.Lock()
+	clients := p.clients
+	p.clients = nil
+	p.mu.Unlock()
+	for _, c := range clients {
+		c.Close()
+	}
+}
+
+//
+// like callErr(), but over a pooled connection.
+//
+func (p *connPool) call(srv string, name string, args interface{}, reply interface{}) error {
+	for attempt := 0; ; attempt++ {
+		c, err := p.get(srv)
+		if err != nil {
+			return &CallError{srv, name, ErrDial, err}
+		}
+		err = c.Call(name, args, reply)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(rpc.ServerError); ok {
+			// the connection's fine; the handler failed.
+			return classifyCallErr(srv, name, err)
+		}
+		p.drop(srv, c)
+		// ErrShutdown means the connection had already broken
+		// before this call went out, e.g. because the server
+		// restarted since the last one, so the handler can't
+		// have run. go again on a fresh connection.
+		if err == rpc.ErrShutdown && attempt == 0 {
+			continue
+		}
+		return classifyCallErr(srv, name, err)
+	}
+}
+
+// send an RPC to srv, over a pooled connection if
+// Config.ReuseConns is set. returns true if srv replied.
+func (px *Paxos) call(srv string, name string, args interface{}, reply interface{}) bool {
+	if !px.config.ReuseConns {
+		return call(srv, name, args, reply)
+	}
+	return px.pool.call(srv, name, args, reply) == nil
+}
+
+// serve RPCs on conn until it closes, or this peer is killed.
+func (px *Paxos) serveConn(rpcs *rpc.Server, conn net.Conn) {
+	px.connMu.Lock()
+	if px.isdead() {
+		px.connMu.Unlock()
+		conn.Close()
+		return
+	}
+	if px.conns == nil {
+		px.conns = map[net.Conn]bool{}
+	}
+	px.conns[conn] = true
+	px.connMu.Unlock()
+
+	rpcs.ServeConn(deadConn{conn, px})
+
+	px.connMu.Lock()
+	delete(px.conns, conn)
+	px.connMu.Unlock()
+}
+
+// a connection being served, which stops delivering
+// requests as soon as the peer is killed, before
+// closeWhenDead() gets round to closing it.
+type deadConn struct {
+	net.Conn
+	px *Paxos
+}
+
+func (c deadConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if c.px.isdead() {
+		return 0, io.EOF
+	}
+	return n, err
+}
+
+// close the connections this peer is serving, and its pool.
+func (px *Paxos) closeConns() {
+	px.connMu.Lock()
+	for conn := range px.conns {
+		conn.Close()
+	}
+	px.conns = nil
+	px.connMu.Unlock()
+	px.pool.close()
+}
@@ -0,0 +1,93 @@
+package paxos
+
+//
+// waiting for a contiguous run of decided instances, which
+// is what a replicated state machine needs before it can
+// apply them in order.
+//
+
+import (
+	"context"
+	"encoding/gob"
+	"time"
+)
+
+//
+// the value proposed to fill a gap that nobody else is
+// filling. applications should apply it as "do nothing",
+// or set Config.HideNoOps and not see it at all.
+//
+type NoOp struct{}
+
+// is v the NoOp the library fills gaps with?
+func isNoOp(v interface{}) bool {
+	_, ok := v.(NoOp)
+	return ok
+}
+
+func init() {
+	gob.Register(NoOp{})
+}
+
+//
+// the lowest seq >= Min() that this peer doesn't know
+// to be decided.
+//
+func (px *Paxos) FirstGap() int {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	return px.firstGapLocked()
+}
+
+// caller must hold px.mu.
+func (px *Paxos) firstGapLocked() int {
+	seq := px.minLocked()
+	for {
+		instance, ok := px.lookupLocked(seq)
+		if !ok || instance.state != Decided {
+			return seq
+		}
+		seq++
+	}
+}
+
+//
+// block until every instance from Min() to n is decided
+// here, or ctx is done. if Config.FillGapsAfter is set and
+// a gap is still open that long after the call, propose
+// NoOp{} for each undecided instance up to n; whatever was
+// already accepted for an instance still wins.
+//
+func (px *Paxos) WaitForPrefix(ctx context.Context, n int) error {
+	var fill <-chan time.Time
+	if px.config.FillGapsAfter > 0 {
+		fill = px.clock.After(px.config.FillGapsAfter)
+	}
+
+	for {
+		px.mu.Lock()
+		gap := px.firstGapLocked()
+		if gap > n {
+			px.mu.Unlock()
+			return nil
+		}
+		ch := make(chan struct{})
+		px.waiters[gap] = append(px.waiters[gap], ch)
+		px.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-fill:
+			px.dropWaiter(gap, ch)
+			fill = nil
+			for seq := gap; seq <= n; seq++ {
+				if fate, _ := px.Status(seq); fate == Pending {
+					px.startOrQueue(seq, "", NoOp{})
+				}
+			}
+		case <-ctx.Done():
+			px.dropWaiter(gap, ch)
+			return ctx.Err()
+		}
+	}
+}
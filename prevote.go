@@ -0,0 +1,97 @@
+package paxos
+
+//
+// Pre-voting.
+//
+// a proposer that has been cut off, and comes back while
+// another proposer is getting an instance agreed, would
+// preempt it with a fresh pnum and start a duel. with
+// Config.PreVoteWindow set, a proposer first asks whether
+// a majority would promise it, without anyone actually
+// promising. an acceptor says no if it promised a different
+// proposer within the window; so a healthy proposer gets to
+// finish, and a stalled one is only taken over once it has
+// been quiet for the window.
+//
+
+type PreVoteArgs struct {
+	Seq     int
+	PNum    string
+	GroupID int
+}
+
+type PreVoteReply struct {
+	Err     string
+	Decided bool // the instance is already decided, as follows
+	PNum    string
+	Value   interface{}
+}
+
+// would this peer grant a Prepare for args? changes nothing.
+func (px *Paxos) PreVote(args *PreVoteArgs, reply *PreVoteReply) (err error) {
+	defer px.recoverHandler("Paxos.PreVote", &err)
+	if !px.intercept("Paxos.PreVote", args) {
+		return ErrDropped
+	}
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	reply.Err = Reject
+	if px.ispaused() || px.leasedAwayLocked(args.PNum) {
+		return nil
+	}
+	instance, ok := px.lookupLocked(args.Seq)
+	if !ok {
+		reply.Err = OK
+		return nil
+	}
+	if instance.state == Decided {
+		reply.Decided = true
+		reply.PNum = instance.n_a
+		reply.Value = px.packValue(instance.v_a)
+		return nil
+	}
+	if !grantable(args.PNum, instance.n_p) {
+		return nil
+	}
+	_, promised, _ := splitPNum(instance.n_p)
+	_, asking, _ := splitPNum(args.PNum)
+	if instance.n_p != "" && promised != asking &&
+		px.clock.Now().Sub(instance.promisedAt) < px.config.PreVoteWindow {
+		return nil
+	}
+	reply.Err = OK
+	return nil
+}
+
+//
+// if Config.PreVoteWindow is set, ask every peer whether
+// they'd grant a Prepare for seq with pnum, and return
+// true only if a majority would. sets *preempted if any
+// peer said no. if a peer says seq is already decided,
+// learns that and returns false. always true if
+// pre-voting is off.
+//
+func (px *Paxos) preVote(seq int, pnum string, preempted *bool) bool {
+	if px.config.PreVoteWindow <= 0 {
+		return true
+	}
+	args := PreVoteArgs{Seq: seq, PNum: pnum, GroupID: px.group}
+	granted := 0
+	for i := range px.peers {
+		var reply PreVoteReply
+		replied := px.invoke(i, "Paxos.PreVote", &args, &reply)
+		if reply.Decided {
+			px.mu.Lock()
+			px.decideLocked(seq, reply.PNum, unpackValue(reply.Value))
+			px.mu.Unlock()
+			return false
+		}
+		if reply.Err == OK {
+			granted++
+		} else if replied {
+			*preempted = true
+		}
+	}
+	return granted >= px.majority()
+}
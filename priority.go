@@ -0,0 +1,38 @@
+package paxos
+
+//
+// priority for starved proposers, for Config.PriorityAfter.
+//
+// pnums follow the clock, so a proposer whose clock runs
+// behind, or that always starts its rounds a little later
+// than a busy rival, can lose every duel for an instance
+// and never get its value in. with PriorityAfter set, a
+// proposer preempted that many rounds running jumps its
+// next pnum PriorityBoost ahead of its clock, which beats
+// every rival whose clock is less than that far ahead of
+// its own, and keeps beating them for about that long:
+// long enough to finish a round, if the boost is longer
+// than a round takes.
+//
+// the trade-off is livelock. a boosted pnum preempts
+// whoever is running, even a proposer that was one Accept
+// away from deciding, and if several proposers are starved
+// at once they all boost, and duel as before, only further
+// ahead. so the boost only guarantees progress to a lone
+// loser; PreemptBackoff is still what breaks up a duel
+// between equals, and a small PriorityAfter turns ordinary
+// contention into boosted contention. boosts also run this
+// peer's pnums ahead of its clock, since a pnum is never
+// lower than the last: after a boost, its pnums go on from
+// there, and are that much more likely to win until the
+// clock catches up.
+//
+
+// the pnum for a proposer's next round, after streak
+// rounds in a row were preempted.
+func (px *Paxos) nextPNum(streak int) (string, error) {
+	if px.config.PriorityAfter > 0 && streak >= px.config.PriorityAfter {
+		return px.generatePNumAhead(px.config.PriorityBoost)
+	}
+	return px.generatePNum()
+}
@@ -0,0 +1,109 @@
+package paxos
+
+//
+// bounding the proposals in flight, for Config.MaxProposers.
+//
+// each Start() runs its proposal in a goroutine of its own,
+// so an application that starts instances faster than they
+// are agreed piles them up without bound. with MaxProposers
+// set, a proposal needs one of that many slots to run, and
+// gives it back when it ends. Start(), which has no way to
+// say it's busy, queues a proposal that finds no free slot,
+// up to maxQueued of them, and the next slot given back
+// runs it unless it has been decided or forgotten in the
+// meantime; StartUnique() and the others that can return an
+// error refuse with ErrBusy; Propose() waits for one, until
+// its context is done.
+//
+
+import "sync/atomic"
+
+// how many Start()s may wait for a slot. more are dropped,
+// and logged, as if they'd been refused with ErrBusy.
+const maxQueued = 1000
+
+// a proposal waiting in px.queued for a slot.
+type queuedProposal struct {
+	seq  int
+	pnum string
+	v    interface{}
+}
+
+// take a proposer slot, waiting for one until done is
+// closed; a nil done doesn't wait. true if one was taken.
+func (px *Paxos) acquireProposer(done <-chan struct{}) bool {
+	if px.proposers == nil {
+		return true
+	}
+	select {
+	case px.proposers <- struct{}{}:
+	default:
+		if done == nil {
+			return false
+		}
+		select {
+		case px.proposers <- struct{}{}:
+		case <-done:
+			return false
+		}
+	}
+	n := atomic.AddInt64(&px.inFlight, 1)
+	for {
+		peak := atomic.LoadInt64(&px.peakFlight)
+		if n <= peak || atomic.CompareAndSwapInt64(&px.peakFlight, peak, n) {
+			break
+		}
+	}
+	return true
+}
+
+// give back a slot taken by acquireProposer(), or hand it
+// straight to the oldest queued proposal that's still
+// needed, if there is one.
+func (px *Paxos) releaseProposer() {
+	if px.proposers == nil {
+		return
+	}
+	px.mu.Lock()
+	for len(px.queued) > 0 {
+		q := px.queued[0]
+		px.queued = px.queued[1:]
+		if q.seq < px.minLocked() {
+			continue
+		}
+		if instance, ok := px.lookupLocked(q.seq); ok && instance.state == Decided {
+			continue
+		}
+		px.mu.Unlock()
+		px.launch(q.seq, q.pnum, q.v, nil)
+		return
+	}
+	atomic.AddInt64(&px.inFlight, -1)
+	<-px.proposers
+	px.mu.Unlock()
+}
+
+// start(), but queue the proposal for the next free slot
+// rather than refuse it with ErrBusy.
+func (px *Paxos) startOrQueue(seq int, pnum string, v interface{}) error {
+	if err := px.start(seq, pnum, v, nil, nil); err != ErrBusy {
+		return err
+	}
+	// a slot given back since start() looked would find the
+	// queue empty, so look again under px.mu, which
+	// releaseProposer() holds while it checks.
+	px.mu.Lock()
+	if px.acquireProposer(nil) {
+		px.mu.Unlock()
+		px.launch(seq, pnum, v, nil)
+		return nil
+	}
+	if len(px.queued) >= maxQueued {
+		px.mu.Unlock()
+		px.logf("Paxos(%v) dropping Start(%v): %v Starts already waiting for a slot", px.me, seq, maxQueued)
+		return ErrBusy
+	}
+	px.queued = append(px.queued, queuedProposal{seq, pnum, v})
+	px.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,86 @@
+package paxos
+
+//
+// Config.StrictSafety: check the acceptor's invariants on
+// every change to an instance, and panic if one breaks,
+// rather than carry on with state that may let two values
+// be decided. for development and CI; it costs a copy and
+// a few comparisons per message.
+//
+
+import "fmt"
+
+//
+// what StrictSafety panics with. RPC handlers let it
+// through rather than turning it into an error reply.
+//
+type SafetyViolation struct {
+	Me     int
+	Seq    int
+	What   string
+	Before instance
+	After  instance
+}
+
+func (v *SafetyViolation) Error() string {
+	return fmt.Sprintf("paxos: safety violation: Paxos(%v) instance %v: %v "+
+		"(before: %v n_p %q n_a %q v_a %v; after: %v n_p %q n_a %q v_a %v)",
+		v.Me, v.Seq, v.What,
+		v.Before.state, v.Before.n_p, v.Before.n_a, v.Before.v_a,
+		v.After.state, v.After.n_p, v.After.n_a, v.After.v_a)
+}
+
+// a copy of instance seq as it is now, or nil if there's
+// none, for checkLocked() to compare against.
+// caller must hold px.mu.
+func (px *Paxos) copyInstanceLocked(seq int) *instance {
+	instance, ok := px.lookupLocked(seq)
+	if !ok {
+		return nil
+	}
+	copy := *instance
+	return &copy
+}
+
+// panic if going from before to instance seq's current
+// state broke an invariant. caller must hold px.mu.
+func (px *Paxos) checkLocked(seq int, before *instance) {
+	after, ok := px.instances[seq]
+	if !ok {
+		return
+	}
+	if before == nil {
+		before = px.newInstance()
+	}
+	if what := checkTransition(before, after, px.valueEqual); what != "" {
+		panic(&SafetyViolation{px.me, seq, what, *before, *after})
+	}
+}
+
+// what's wrong with an acceptor going from before to
+// after, or "" if nothing. values are compared with equal.
+func checkTransition(before *instance, after *instance, equal func(a, b interface{}) bool) string {
+	if before.state == Decided {
+		if after.state != Decided {
+			return "decided instance became undecided"
+		}
+		if !equal(before.v_a, after.v_a) {
+			return "decided value changed"
+		}
+		return ""
+	}
+	if after.n_a != "" && comparePNum(after.n_a, after.n_p) > 0 {
+		return "accepted above its promise"
+	}
+	if after.state == Decided {
+		// once decided, promises no longer matter.
+		return ""
+	}
+	if comparePNum(after.n_p, before.n_p) < 0 {
+		return "promise went down"
+	}
+	if comparePNum(after.n_a, before.n_a) < 0 {
+		return "accepted pnum went down"
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package paxos
+
+//
+// checking at startup that this peer can be reached at its
+// own address.
+//
+// a peer never dials itself to agree on anything: invoke()
+// calls its own handlers directly. so if it's listening
+// somewhere other than where peers[me] says, e.g. because
+// Config.Listener was set up on the wrong path, it goes on
+// agreeing with itself while the others can't reach it,
+// and the mistake only shows as a peer that's mysteriously
+// always behind. Make() connects to peers[me] once, as the
+// other peers would, from an address of its own that
+// serve() recognizes, so the mistake shows up straight
+// away. the probe carries no RPC, so it isn't counted in
+// rpcCount or dropped by setunreliable().
+//
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// how long Make() waits for its probe to connect, and
+// then to arrive at serve().
+const selfDialTimeout = time.Second
+
+// the address the probe connects from.
+func (px *Paxos) probeAddr() string {
+	return px.resolve(px.me) + ".probe"
+}
+
+// is conn Make()'s probe, rather than another peer's call?
+func (px *Paxos) isProbe(conn net.Conn) bool {
+	a, ok := conn.RemoteAddr().(*net.UnixAddr)
+	return ok && a != nil && a.Name != "" && a.Name == px.probeAddr()
+}
+
+// connect to this peer's own address and wait for serve()
+// to see the probe. returns an error describing the
+// misconfiguration if it doesn't get there in time.
+func (px *Paxos) checkSelfDial() error {
+	addr := px.resolve(px.me)
+	from := &net.UnixAddr{Name: px.probeAddr(), Net: "unix"}
+	os.Remove(from.Name)
+	defer os.Remove(from.Name)
+
+	d := net.Dialer{Timeout: selfDialTimeout, LocalAddr: from}
+	conn, err := d.Dial("unix", addr)
+	if err != nil {
+		return fmt.Errorf("paxos: peer %v can't reach itself at %v, so neither can the other peers: %v",
+			px.me, addr, err)
+	}
+	conn.Close()
+
+	select {
+	case <-px.probed:
+		return nil
+	case <-time.After(selfDialTimeout):
+		return fmt.Errorf("paxos: peer %v can't reach itself at %v, so neither can the other peers: "+
+			"something else is listening there", px.me, addr)
+	}
+}
@@ -0,0 +1,183 @@
+package paxos
+
+//
+// spilling instances to the Persister, for Config.SpillAbove.
+//
+// a peer can't forget an instance until every peer has
+// called Done() past it, so one slow peer pins Min() and
+// the others' instances pile up in memory. with SpillAbove
+// set, once a peer has more instances than that in memory,
+// Done() moves the decided ones it has itself finished with
+// out to the Persister, keeping just their seqs. a lagging
+// peer's catch-up, or a Query or Status(), reads them back
+// from there; a Prepare, Accept or Decide for one brings it
+// back into memory, so the acceptor never loses what it
+// promised, and one that can't be read back is refused
+// rather than started over. they're dropped from the Persister once Min()
+// passes them.
+//
+// the seqs that are spilled are kept in the Persister too,
+// so that a restarted peer finds its spilled instances
+// again. CompactWAL() keeps their records, read back from
+// the Persister, since a spilled instance is only as
+// durable as the WAL and the Persister together make it.
+//
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// the Persister key spilled instance seq is kept under.
+func spillKey(seq int) string {
+	return "instance-" + strconv.Itoa(seq)
+}
+
+// the Persister key the list of spilled seqs is kept under.
+const spilledKey = "spilled"
+
+// save the list of spilled seqs. caller must hold px.mu.
+func (px *Paxos) saveSpilledLocked() {
+	seqs := make([]int, 0, len(px.spilled))
+	for seq := range px.spilled {
+		seqs = append(seqs, seq)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(seqs); err != nil {
+		px.logf("Paxos(%v) can't save the spilled seqs: %v", px.me, err)
+		return
+	}
+	if err := px.config.Persister.Save(spilledKey, buf.Bytes()); err != nil {
+		px.logf("Paxos(%v) can't save the spilled seqs: %v", px.me, err)
+	}
+}
+
+// find the instances spilled before a restart. must run
+// before replayWAL(), which leaves spilled seqs alone: an
+// instance is only spilled after its last change, so its
+// spilled record is newer than any in the WAL.
+func (px *Paxos) loadSpilled() error {
+	if px.config.Persister == nil {
+		return nil
+	}
+	data, err := px.config.Persister.Load(spilledKey)
+	if err != nil || len(data) == 0 {
+		return err
+	}
+	var seqs []int
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&seqs); err != nil {
+		return fmt.Errorf("paxos: bad saved spilled seqs: %v", err)
+	}
+	px.spilled = map[int]bool{}
+	for _, seq := range seqs {
+		px.spilled[seq] = true
+		if seq > px.maxSeen {
+			px.maxSeen = seq
+		}
+	}
+	return nil
+}
+
+// spill decided instances this peer is Done() with, if
+// there are more than Config.SpillAbove in memory.
+// caller must hold px.mu.
+func (px *Paxos) spillLocked() {
+	if px.config.SpillAbove <= 0 || px.config.Persister == nil || len(px.instances) <= px.config.SpillAbove {
+		return
+	}
+	min := px.lowestDoneLocked()
+	n := len(px.spilled)
+	for seq, instance := range px.instances {
+		if seq <= min || seq > px.dones[px.me] || instance.state != Decided {
+			continue
+		}
+		var buf bytes.Buffer
+		rec := walRecord{Seq: seq, NP: instance.n_p, NA: instance.n_a, V: instance.v_a, State: instance.state,
+			DecidedAt: instance.decidedAt}
+		if err := gob.NewEncoder(&buf).Encode(&rec); err != nil {
+			px.logf("Paxos(%v) can't spill instance %v: %v", px.me, seq, err)
+			continue
+		}
+		if err := px.config.Persister.Save(spillKey(seq), buf.Bytes()); err != nil {
+			px.logf("Paxos(%v) can't spill instance %v: %v", px.me, seq, err)
+			continue
+		}
+		if px.spilled == nil {
+			px.spilled = map[int]bool{}
+		}
+		px.spilled[seq] = true
+		delete(px.instances, seq)
+	}
+	if len(px.spilled) != n {
+		px.saveSpilledLocked()
+	}
+}
+
+// read spilled instance seq back, leaving it spilled. nil
+// if it isn't spilled; an error if it is but its record
+// can't be read, since then nothing may stand in for it.
+// caller must hold px.mu.
+func (px *Paxos) unspillLocked(seq int) (*instance, error) {
+	if !px.spilled[seq] {
+		return nil, nil
+	}
+	data, err := px.config.Persister.Load(spillKey(seq))
+	if err == nil && data == nil {
+		err = errors.New("no record")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("paxos: can't load spilled instance %v: %v", seq, err)
+	}
+	var rec walRecord
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&rec); err != nil {
+		return nil, fmt.Errorf("paxos: can't load spilled instance %v: %v", seq, err)
+	}
+	instance := px.newInstance()
+	instance.n_p = rec.NP
+	instance.n_a = rec.NA
+	instance.v_a = rec.V
+	instance.state = rec.State
+	instance.decidedAt = rec.DecidedAt
+	return instance, nil
+}
+
+// instance seq, from memory or from where it was spilled.
+// for reading only: a spilled one isn't brought back, and
+// one that can't be read is logged and reported missing.
+// caller must hold px.mu.
+func (px *Paxos) lookupLocked(seq int) (*instance, bool) {
+	if instance, ok := px.instances[seq]; ok {
+		return instance, true
+	}
+	instance, err := px.unspillLocked(seq)
+	if err != nil {
+		px.logf("Paxos(%v) %v", px.me, err)
+	}
+	return instance, instance != nil
+}
+
+// drop spilled instances that are now forgotten.
+// caller must hold px.mu.
+func (px *Paxos) forgetSpilledLocked(min int) {
+	var dropped []int
+	for seq := range px.spilled {
+		if seq <= min {
+			delete(px.spilled, seq)
+			dropped = append(dropped, seq)
+		}
+	}
+	if len(dropped) == 0 {
+		return
+	}
+	// forget them before dropping their records, so that a
+	// crash in between leaves no seq without its record.
+	px.saveSpilledLocked()
+	for _, seq := range dropped {
+		if err := px.config.Persister.Save(spillKey(seq), nil); err != nil {
+			px.logf("Paxos(%v) can't drop spilled instance %v: %v", px.me, seq, err)
+		}
+	}
+}
@@ -6,10 +6,23 @@ import "strconv"
 import "os"
 import "time"
 import "fmt"
+import "math"
 import "math/rand"
 import crand "crypto/rand"
 import "encoding/base64"
 import "sync/atomic"
+import "net"
+import "net/rpc"
+import "errors"
+import "context"
+import "sync"
+import "strings"
+import "encoding/json"
+import "bytes"
+import "reflect"
+import "sort"
+import "log"
+import "net/http/httptest"
 
 func randstring(n int) string {
 	b := make([]byte, 2*n)
@@ -85,6 +98,30 @@ func cleanup(pxa []*Paxos) {
 	}
 }
 
+// peer me of npeers, named by tag, for calling handlers and
+// accessors on directly: it's registered with an RPC server
+// of its own but doesn't listen, so nothing else reaches it.
+// killed when the test ends.
+func loosePeer(t testing.TB, tag string, npeers int, me int, cfg Config) *Paxos {
+	peers := make([]string, npeers)
+	for i := range peers {
+		peers[i] = port(tag, i)
+	}
+	px, err := MakeWithConfig(peers, me, rpc.NewServer(), cfg)
+	if err != nil {
+		t.Fatalf("MakeWithConfig: %v", err)
+	}
+	t.Cleanup(px.Kill)
+	return px
+}
+
+// a new pnum from px, for tests that are nowhere near the
+// counter's limit.
+func freshPNum(px *Paxos) string {
+	pnum, _ := px.generatePNum()
+	return pnum
+}
+
 func noTestSpeed(t *testing.T) {
 	runtime.GOMAXPROCS(4)
 
@@ -955,3 +992,5897 @@ func TestLots(t *testing.T) {
 
 	fmt.Printf("  ... Passed\n")
 }
+
+//
+// a listener whose Accept() always fails, with a temporary
+// error until closed and a permanent one after that.
+//
+type tempError struct{}
+
+func (tempError) Error() string   { return "temporary accept failure" }
+func (tempError) Timeout() bool   { return false }
+func (tempError) Temporary() bool { return true }
+
+type flakyListener struct {
+	accepts int32
+	closed  int32
+	fatal   bool
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	atomic.AddInt32(&l.accepts, 1)
+	if l.fatal || atomic.LoadInt32(&l.closed) != 0 {
+		return nil, errors.New("listener closed")
+	}
+	return nil, tempError{}
+}
+
+func (l *flakyListener) Close() error {
+	atomic.StoreInt32(&l.closed, 1)
+	return nil
+}
+
+func (l *flakyListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "flaky", Net: "unix"}
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Accept loop backs off on temporary errors ...\n")
+
+	fl := &flakyListener{}
+	px := &Paxos{l: fl, clock: realClock{}}
+	go px.serve(rpc.NewServer())
+
+	time.Sleep(300 * time.Millisecond)
+	px.Kill()
+
+	// 5ms, 10ms, 20ms, 40ms, 80ms, 160ms ... so only a
+	// handful of attempts fit in 300ms.
+	n := atomic.LoadInt32(&fl.accepts)
+	if n > 20 {
+		t.Fatalf("accept loop spun; %v Accept() calls in 300ms", n)
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: Accept loop stops on fatal errors ...\n")
+
+	fl = &flakyListener{fatal: true}
+	px = &Paxos{l: fl, clock: realClock{}}
+	ch := make(chan bool)
+	go func() {
+		px.serve(rpc.NewServer())
+		ch <- true
+	}()
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("accept loop did not stop after a fatal error")
+	}
+	if n := atomic.LoadInt32(&fl.accepts); n != 1 {
+		t.Fatalf("expected 1 Accept() call, got %v", n)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestPropose(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("propose", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	fmt.Printf("Test: Propose returns the winning value ...\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var vals [2]interface{}
+	var errs [2]error
+	ch := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func(i int) {
+			vals[i], errs[i] = pxa[i].Propose(ctx, 0, 100+i)
+			ch <- true
+		}(i)
+	}
+	<-ch
+	<-ch
+	for i := 0; i < 2; i++ {
+		if errs[i] != nil {
+			t.Fatalf("Propose on peer %v: %v", i, errs[i])
+		}
+	}
+	if vals[0] != vals[1] {
+		t.Fatalf("Propose returned different values %v and %v", vals[0], vals[1])
+	}
+	if vals[0] != 100 && vals[0] != 101 {
+		t.Fatalf("Propose returned a value nobody proposed: %v", vals[0])
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: Propose respects context ...\n")
+
+	pxa[2].Kill()
+	pxa[1].Kill()
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel2()
+	if _, err := pxa[0].Propose(ctx2, 1, "x"); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	// and doesn't leave its waiter behind.
+	pxa[0].mu.Lock()
+	n := len(pxa[0].waiters[1])
+	pxa[0].mu.Unlock()
+	if n != 0 {
+		t.Fatalf("%v waiters left on seq 1 after Propose gave up", n)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestVerifyConsensus(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("verify", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	fmt.Printf("Test: VerifyConsensus agrees after a decision ...\n")
+
+	pxa[0].Start(0, "hello")
+	waitn(t, pxa, 0, npaxos)
+	for i := 0; i < npaxos; i++ {
+		ok, err := pxa[i].VerifyConsensus(0)
+		if err != nil || !ok {
+			t.Fatalf("VerifyConsensus on peer %v: %v, %v", i, ok, err)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: VerifyConsensus detects divergence ...\n")
+
+	pxa[2].mu.Lock()
+	pxa[2].instances[0].v_a = "goodbye"
+	pxa[2].mu.Unlock()
+	ok, err := pxa[0].VerifyConsensus(0)
+	if err != nil || ok {
+		t.Fatalf("VerifyConsensus missed a divergence: %v, %v", ok, err)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestMux(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Groups sharing a Mux agree independently ...\n")
+
+	const npaxos = 3
+	const ngroups = 3
+	var mxa []*Mux = make([]*Mux, npaxos)
+	var pxh []string = make([]string, npaxos)
+	var pxa [ngroups][]*Paxos
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("mux", i)
+		mx, err := NewMux(pxh[i])
+		if err != nil {
+			t.Fatalf("NewMux: %v", err)
+		}
+		mxa[i] = mx
+		defer mx.Close()
+	}
+	for g := 0; g < ngroups; g++ {
+		pxa[g] = make([]*Paxos, npaxos)
+		for i := 0; i < npaxos; i++ {
+			pxa[g][i] = mxa[i].Make(g, pxh, i)
+		}
+		defer cleanup(pxa[g])
+	}
+
+	for g := 0; g < ngroups; g++ {
+		pxa[g][g%npaxos].Start(0, g*100)
+	}
+	for g := 0; g < ngroups; g++ {
+		waitn(t, pxa[g], 0, npaxos)
+		for i := 0; i < npaxos; i++ {
+			_, v := pxa[g][i].Status(0)
+			if v != g*100 {
+				t.Fatalf("group %v decided %v; expected %v", g, v, g*100)
+			}
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestDoneVector(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("donevec", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	fmt.Printf("Test: DoneVector reports each peer's Done ...\n")
+
+	dv := pxa[0].DoneVector()
+	for i := 0; i < npaxos; i++ {
+		if dv[i] != -1 {
+			t.Fatalf("initial DoneVector %v; expected all -1", dv)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		pxa[0].Start(i, i)
+		waitn(t, pxa, i, npaxos)
+	}
+	pxa[1].Done(0)
+	pxa[2].Done(1)
+
+	// piggyback the Done()s on a decision from each peer.
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Start(3+i, "x")
+		waitn(t, pxa, 3+i, npaxos)
+	}
+
+	expected := []int{-1, 0, 1}
+	for i := 0; i < npaxos; i++ {
+		dv := pxa[i].DoneVector()
+		for j := 0; j < npaxos; j++ {
+			if dv[j] != expected[j] {
+				t.Fatalf("peer %v DoneVector %v; expected %v", i, dv, expected)
+			}
+		}
+	}
+
+	// the copy is the caller's.
+	dv = pxa[0].DoneVector()
+	dv[0] = 100
+	if pxa[0].DoneVector()[0] != -1 {
+		t.Fatalf("DoneVector returned internal state")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestConsistentStatus(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	tag := "cstatus"
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	defer cleanup(pxa)
+	defer cleanpp(tag, npaxos)
+
+	for i := 0; i < npaxos; i++ {
+		var pxh []string = make([]string, npaxos)
+		for j := 0; j < npaxos; j++ {
+			if j == i {
+				pxh[j] = port(tag, i)
+			} else {
+				pxh[j] = pp(tag, i, j)
+			}
+		}
+		pxa[i] = Make(pxh, i, nil)
+	}
+	defer part(t, tag, npaxos, []int{}, []int{}, []int{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fmt.Printf("Test: ConsistentStatus needs a majority ...\n")
+
+	part(t, tag, npaxos, []int{0, 1}, []int{2}, []int{})
+	if _, _, err := pxa[2].ConsistentStatus(ctx, 0); err != ErrNoMajority {
+		t.Fatalf("expected ErrNoMajority from minority side, got %v", err)
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: ConsistentStatus sees past stale local state ...\n")
+
+	pxa[0].Start(0, "hello")
+	waitmajority(t, pxa, 0)
+	part(t, tag, npaxos, []int{0, 1, 2}, []int{}, []int{})
+
+	if fate, _ := pxa[2].Status(0); fate != Pending {
+		t.Fatalf("expected stale Pending from healed peer's Status, got %v", fate)
+	}
+	fate, v, err := pxa[2].ConsistentStatus(ctx, 0)
+	if err != nil || fate != Decided || v != "hello" {
+		t.Fatalf("ConsistentStatus returned %v, %v, %v", fate, v, err)
+	}
+
+	fate, _, err = pxa[2].ConsistentStatus(ctx, 1)
+	if err != nil || fate != Pending {
+		t.Fatalf("ConsistentStatus of unstarted instance returned %v, %v", fate, err)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestSelectValue(t *testing.T) {
+	fmt.Printf("Test: Proposer picks the right value to accept ...\n")
+
+	none := PrepareReply{Err: OK}
+	rejected := PrepareReply{Err: Reject, AcceptPnum: "99-2", AcceptValue: "rejected", HasAccepted: true}
+
+	// nobody has accepted anything: our own value.
+	v, _ := selectValue("mine", []PrepareReply{none, none, none}, reflect.DeepEqual)
+	if v != "mine" {
+		t.Fatalf("nothing accepted; chose %v, expected mine", v)
+	}
+
+	// a Reject doesn't count, even with an accepted value.
+	v, _ = selectValue("mine", []PrepareReply{none, rejected, none}, reflect.DeepEqual)
+	if v != "mine" {
+		t.Fatalf("accepted value from a Reject; chose %v, expected mine", v)
+	}
+
+	// exactly one peer accepted something: that value.
+	one := PrepareReply{Err: OK, AcceptPnum: "5-1", AcceptValue: "theirs", HasAccepted: true}
+	for i := 0; i < 3; i++ {
+		replies := []PrepareReply{none, none, none}
+		replies[i] = one
+		v, _ = selectValue("mine", replies, reflect.DeepEqual)
+		if v != "theirs" {
+			t.Fatalf("one accepted at %v; chose %v, expected theirs", i, v)
+		}
+	}
+
+	// several accepted different values: highest pnum wins,
+	// compared numerically rather than as strings.
+	low := PrepareReply{Err: OK, AcceptPnum: "9-1", AcceptValue: "low", HasAccepted: true}
+	high := PrepareReply{Err: OK, AcceptPnum: "10-0", AcceptValue: "high", HasAccepted: true}
+	v, _ = selectValue("mine", []PrepareReply{low, high, none}, reflect.DeepEqual)
+	if v != "high" {
+		t.Fatalf("chose %v, expected high", v)
+	}
+	v, _ = selectValue("mine", []PrepareReply{high, none, low}, reflect.DeepEqual)
+	if v != "high" {
+		t.Fatalf("chose %v, expected high", v)
+	}
+
+	// the same pnum with different values breaks an invariant:
+	// say so, and choose the same value whatever the order.
+	a := PrepareReply{Err: OK, AcceptPnum: "7-1", AcceptValue: "a", HasAccepted: true}
+	b := PrepareReply{Err: OK, AcceptPnum: "7-1", AcceptValue: "b", HasAccepted: true}
+	for _, replies := range [][]PrepareReply{{a, b, none}, {b, none, a}} {
+		v, err := selectValue("mine", replies, reflect.DeepEqual)
+		if err != ErrConflict {
+			t.Fatalf("equal pnums, different values: err %v, expected ErrConflict", err)
+		}
+		if v != "a" {
+			t.Fatalf("equal pnums, different values: chose %v, expected a", v)
+		}
+	}
+
+	// a higher pnum supersedes the conflict.
+	v, err := selectValue("mine", []PrepareReply{a, b, high}, reflect.DeepEqual)
+	if err != nil || v != "high" {
+		t.Fatalf("conflict below a higher pnum: chose %v, err %v", v, err)
+	}
+
+	// an accepted nil is a value, not the lack of one.
+	accnil := PrepareReply{Err: OK, AcceptPnum: "12-1", AcceptValue: nil, HasAccepted: true}
+	v, err = selectValue("mine", []PrepareReply{low, accnil, none}, reflect.DeepEqual)
+	if err != nil || v != nil {
+		t.Fatalf("accepted nil: chose %v, err %v", v, err)
+	}
+
+	// the same pnum with the same value is fine.
+	v, err = selectValue("mine", []PrepareReply{a, a, none}, reflect.DeepEqual)
+	if err != nil || v != "a" {
+		t.Fatalf("equal pnums, equal values: chose %v, err %v", v, err)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestComparePNum(t *testing.T) {
+	fmt.Printf("Test: Proposer nums compare numerically ...\n")
+
+	cases := []struct {
+		a, b string
+		c    int
+	}{
+		{"", "", 0},
+		{"", "1-0", -1},
+		{"1-0", "", 1},
+		{"9-1", "10-0", -1},
+		{"10-0", "9-1", 1},
+		{"10-1", "10-1", 0},
+		{"10-1", "10-2", -1},
+		{"10-9", "10-10", -1},
+		{"010-0", "10-0", 0},
+	}
+	for _, c := range cases {
+		if got := comparePNum(c.a, c.b); got != c.c {
+			t.Fatalf("comparePNum(%q, %q) = %v; expected %v", c.a, c.b, got, c.c)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+//
+// a Clock that only moves when the test says so.
+// every After() is reported on sleeps.
+//
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+	sleeps chan time.Duration
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:    time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		sleeps: make(chan time.Duration, 100),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.timers = append(c.timers, fakeTimer{c.now.Add(d), ch})
+	select {
+	case c.sleeps <- d:
+	default:
+	}
+	return ch
+}
+
+// move the clock by d (which may be negative) and
+// fire any timers that are now due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	var pending []fakeTimer
+	for _, t := range c.timers {
+		if !t.at.After(c.now) {
+			t.ch <- c.now
+		} else {
+			pending = append(pending, t)
+		}
+	}
+	c.timers = pending
+}
+
+func TestPNumClockBackward(t *testing.T) {
+	fmt.Printf("Test: pnums increase when the clock steps back ...\n")
+
+	fc := newFakeClock()
+	px := &Paxos{clock: fc, me: 1}
+
+	var last string
+	steps := []time.Duration{time.Second, 0, -time.Hour, time.Millisecond, -time.Nanosecond, 2 * time.Hour}
+	for i, d := range steps {
+		fc.Advance(d)
+		pnum, _ := px.generatePNum()
+		if last != "" && comparePNum(pnum, last) <= 0 {
+			t.Fatalf("step %v (%v): pnum %v not above %v", i, d, pnum, last)
+		}
+		last = pnum
+	}
+	// once the clock is ahead again, pnums follow it.
+	begin := time.Date(2017, time.April, 4, 19, 0, 0, 0, time.UTC)
+	want := strconv.FormatInt(fc.Now().Sub(begin).Nanoseconds(), 10) + "-1"
+	if last != want {
+		t.Fatalf("pnum %v after the clock caught up; expected %v", last, want)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestFakeClockBackoff(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Accept backoff sequence with a fake clock ...\n")
+
+	fc := newFakeClock()
+	fl := &flakyListener{}
+	px := &Paxos{l: fl, clock: fc, me: 2}
+	go px.serve(rpc.NewServer())
+	defer px.Kill()
+
+	expected := []time.Duration{
+		5 * time.Millisecond,
+		10 * time.Millisecond,
+		20 * time.Millisecond,
+		40 * time.Millisecond,
+		80 * time.Millisecond,
+		160 * time.Millisecond,
+		320 * time.Millisecond,
+		640 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for i, want := range expected {
+		var d time.Duration
+		select {
+		case d = <-fc.sleeps:
+		case <-time.After(time.Second):
+			t.Fatalf("accept loop didn't back off (step %v)", i)
+		}
+		if d != want {
+			t.Fatalf("backoff step %v slept %v; expected %v", i, d, want)
+		}
+		if n := atomic.LoadInt32(&fl.accepts); int(n) != i+1 {
+			t.Fatalf("%v Accept() calls after %v backoffs", n, i)
+		}
+		fc.Advance(d)
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: Proposer nums follow the injected clock ...\n")
+
+	p1, _ := px.generatePNum()
+	fc.Advance(time.Second)
+	p2, _ := px.generatePNum()
+	if comparePNum(p1, p2) >= 0 {
+		t.Fatalf("pnum didn't advance with the clock: %v then %v", p1, p2)
+	}
+	if p3, _ := px.generatePNum(); comparePNum(p2, p3) >= 0 {
+		t.Fatalf("pnum didn't advance without the clock moving: %v then %v", p2, p3)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestMaxInstances(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("maxinst", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		var err error
+		pxa[i], err = MakeWithConfig(pxh, i, nil, Config{MaxInstances: 3, ExchangeDoneInterval: 50 * time.Millisecond})
+		if err != nil {
+			t.Fatalf("MakeWithConfig: %v", err)
+		}
+	}
+
+	fmt.Printf("Test: MaxInstances rejects runaway proposals ...\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for seq := 0; seq < 3; seq++ {
+		if _, err := pxa[0].Propose(ctx, seq, seq); err != nil {
+			t.Fatalf("Propose(%v): %v", seq, err)
+		}
+	}
+	if _, err := pxa[0].Propose(ctx, 3, 3); err != ErrTooManyInstances {
+		t.Fatalf("expected ErrTooManyInstances, got %v", err)
+	}
+	// instances inside the window are still fine.
+	if v, err := pxa[0].Propose(ctx, 1, 100); err != nil || v != 1 {
+		t.Fatalf("Propose inside the window: %v, %v", v, err)
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: MaxInstances allows more after forgetting ...\n")
+
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Done(1)
+	}
+	// the Done()s spread by exchange, since Start()ing the
+	// decided instances sends nothing.
+	for iters := 0; iters < 100 && pxa[0].Min() != 2; iters++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pxa[0].Min() != 2 {
+		t.Fatalf("Min() didn't advance; got %v", pxa[0].Min())
+	}
+	if _, err := pxa[0].Propose(ctx, 3, 3); err != nil {
+		t.Fatalf("Propose(3) after forgetting: %v", err)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestDuplicatePeers(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Duplicate peer addresses are refused ...\n")
+
+	pxh := []string{port("dup", 0), port("dup", 1), port("dup", 0)}
+	px, err := MakeWithConfig(pxh, 1, nil, Config{})
+	if err == nil {
+		px.Kill()
+		t.Fatalf("MakeWithConfig accepted a duplicate address")
+	}
+	if !strings.Contains(err.Error(), "peers 0 and 2") {
+		t.Fatalf("unclear error: %v", err)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestMakeWithListener(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Agreement with listeners made by the caller ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("listener", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		os.Remove(pxh[i])
+		l, err := net.Listen("unix", pxh[i])
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		if i == 0 {
+			pxa[i], err = MakeWithListener(pxh, i, l, rpc.NewServer())
+		} else {
+			pxa[i], err = MakeWithListener(pxh, i, l, nil)
+		}
+		if err != nil {
+			t.Fatalf("MakeWithListener: %v", err)
+		}
+	}
+
+	pxa[0].Start(0, "heard")
+	waitn(t, pxa, 0, npaxos)
+	pxa[2].Start(1, "also heard")
+	waitn(t, pxa, 1, npaxos)
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestSelfDial(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Make warns when a peer isn't at its own address ...\n")
+
+	pxh := []string{port("selfdial", 0), port("selfdial", 1), port("selfdial", 2)}
+	wrong := port("selfdialwrong", 0)
+	listen := func() net.Listener {
+		os.Remove(wrong)
+		os.Remove(pxh[0])
+		l, err := net.Listen("unix", wrong)
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		return l
+	}
+
+	// listening somewhere other than peers[0]: a warning.
+	var buf bytes.Buffer
+	logger := log.New(&buf, "", 0)
+	px, err := MakeWithConfig(pxh, 0, nil, Config{Listener: listen(), Logger: logger})
+	if err != nil {
+		t.Fatalf("MakeWithConfig: %v", err)
+	}
+	px.Kill()
+	if !strings.Contains(buf.String(), "can't reach itself at "+pxh[0]) {
+		t.Fatalf("no warning about the self address; logged %q", buf.String())
+	}
+
+	// or an error, if asked for.
+	px, err = MakeWithConfig(pxh, 0, nil, Config{Listener: listen(), RequireSelfDial: true})
+	if px != nil || err == nil {
+		t.Fatalf("MakeWithConfig with RequireSelfDial succeeded")
+	}
+
+	// something else at peers[0] that never answers doesn't
+	// hang Make().
+	l := listen()
+	other, err := net.Listen("unix", pxh[0])
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	start := time.Now()
+	px, err = MakeWithConfig(pxh, 0, nil, Config{Listener: l, RequireSelfDial: true})
+	other.Close()
+	if px != nil || err == nil {
+		t.Fatalf("MakeWithConfig with a stranger at its address succeeded")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("MakeWithConfig took %v", d)
+	}
+
+	// and nothing when it's right, and the probe isn't
+	// counted as an RPC.
+	buf.Reset()
+	px, err = MakeWithConfig(pxh, 0, nil, Config{Logger: logger, RequireSelfDial: true})
+	if err != nil {
+		t.Fatalf("MakeWithConfig at the right address: %v", err)
+	}
+	if n := atomic.LoadInt32(&px.rpcCount); n != 0 {
+		t.Fatalf("the probe counted as %v RPCs", n)
+	}
+	px.Kill()
+	if buf.Len() != 0 {
+		t.Fatalf("logged %q", buf.String())
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestRegisterError(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: a server that already has a Paxos is refused ...\n")
+
+	pxh := []string{port("register", 0), port("register", 1)}
+	rpcs := rpc.NewServer()
+	px, err := MakeWithConfig(pxh, 0, rpcs, Config{})
+	if err != nil {
+		t.Fatalf("MakeWithConfig: %v", err)
+	}
+	defer px.Kill()
+
+	px2, err := MakeWithConfig(pxh, 1, rpcs, Config{})
+	if err == nil {
+		px2.Kill()
+		t.Fatalf("second peer registered on a shared server")
+	}
+	if !strings.Contains(err.Error(), "already defined") {
+		t.Fatalf("unclear error: %v", err)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestInstanceFactory(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	pxh := []string{port("factory", 0)}
+	pxa := make([]*Paxos, 1)
+	defer cleanup(pxa)
+	var err error
+	pxa[0], err = MakeWithConfig(pxh, 0, nil, Config{MaxInstances: 2})
+	if err != nil {
+		t.Fatalf("MakeWithConfig: %v", err)
+	}
+	px := pxa[0]
+
+	fmt.Printf("Test: Prepare and Decide create instances within MaxInstances ...\n")
+
+	var preply PrepareReply
+	px.Prepare(&PrepareArgs{Seq: 0, PNum: "1-1"}, &preply)
+	if preply.Err != OK {
+		t.Fatalf("Prepare(0): %v", preply.Err)
+	}
+	px.Decide(&DecideArgs{Seq: 1, Value: "one", PNum: "1-1", Me: 0, Done: -1}, &DecideReply{})
+	if fate, v := px.Status(1); fate != Decided || v != "one" {
+		t.Fatalf("Decide(1): %v, %v", fate, v)
+	}
+	if px.Max() != 1 {
+		t.Fatalf("Max() = %v, want 1", px.Max())
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: a full peer refuses to create more ...\n")
+
+	preply = PrepareReply{}
+	px.Prepare(&PrepareArgs{Seq: 5, PNum: "1-1"}, &preply)
+	if preply.Err != Reject {
+		t.Fatalf("Prepare(5) past the limit: %v", preply.Err)
+	}
+	px.Decide(&DecideArgs{Seq: 6, Value: "six", PNum: "1-1", Me: 0, Done: -1}, &DecideReply{})
+	if fate, _ := px.Status(6); fate != Pending {
+		t.Fatalf("Decide(6) past the limit: %v", fate)
+	}
+	var areply AcceptReply
+	px.Accept(&AcceptArgs{Seq: 7, PNum: "1-1", Value: "seven"}, &areply)
+	if areply.Err != Reject {
+		t.Fatalf("Accept(7) of an unprepared instance: %v", areply.Err)
+	}
+	if px.Max() != 1 {
+		t.Fatalf("Max() = %v after refusals, want 1", px.Max())
+	}
+	// existing instances are still reachable.
+	px.Decide(&DecideArgs{Seq: 0, Value: "zero", PNum: "1-1", Me: 0, Done: -1}, &DecideReply{})
+	if fate, v := px.Status(0); fate != Decided || v != "zero" {
+		t.Fatalf("Decide(0): %v, %v", fate, v)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestMaxProposers(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("maxprop", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		var err error
+		pxa[i], err = MakeWithConfig(pxh, i, nil, Config{MaxProposers: 2})
+		if err != nil {
+			t.Fatalf("MakeWithConfig: %v", err)
+		}
+	}
+
+	fmt.Printf("Test: MaxProposers queues or refuses proposals beyond the limit ...\n")
+
+	// no majority, so proposals hang on to their slots.
+	pxa[1].Pause()
+	pxa[2].Pause()
+	pxa[0].Start(0, "a")
+	pxa[0].Start(1, "b")
+	if _, err := pxa[0].StartUnique("c", "c"); err != ErrBusy {
+		t.Fatalf("third StartUnique: %v, expected ErrBusy", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	if _, err := pxa[0].Propose(ctx, 3, "d"); err != context.DeadlineExceeded {
+		t.Fatalf("Propose waiting for a slot: %v", err)
+	}
+	cancel()
+	pxa[0].Start(4, "e") // queued
+	// queued, then decided elsewhere while it waits.
+	pxa[0].Start(5, "f")
+	pxa[0].Decide(&DecideArgs{Seq: 5, Value: "g", PNum: "1-1", Me: 1, Done: -1}, &DecideReply{})
+
+	// the queue is bounded.
+	for seq := 100; seq < 100+maxQueued; seq++ {
+		pxa[0].Start(seq, seq)
+	}
+	pxa[0].mu.Lock()
+	queued := len(pxa[0].queued)
+	pxa[0].mu.Unlock()
+	if queued != maxQueued {
+		t.Fatalf("%v Starts queued, limit %v", queued, maxQueued)
+	}
+	if err := pxa[0].startOrQueue(99, "", 99); err != ErrBusy {
+		t.Fatalf("Start beyond a full queue: %v, expected ErrBusy", err)
+	}
+	for seq := 100; seq < 100+maxQueued; seq++ {
+		pxa[0].CancelProposal(seq)
+	}
+	proposed := atomic.LoadInt64(&pxa[0].proposed)
+
+	pxa[1].Resume()
+	pxa[2].Resume()
+	waitn(t, pxa, 0, npaxos)
+	waitn(t, pxa, 1, npaxos)
+	waitn(t, pxa, 4, npaxos)
+	for iters := 0; iters < 50 && atomic.LoadInt64(&pxa[0].inFlight) != 0; iters++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&pxa[0].proposed) - proposed; n != 1 {
+		t.Fatalf("%v queued proposals ran; expected only seq 4's", n)
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: Propose waits for a slot under load ...\n")
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for seq := 10; seq < 30; seq++ {
+		wg.Add(1)
+		go func(seq int) {
+			defer wg.Done()
+			_, err := pxa[0].Propose(ctx, seq, seq)
+			errs <- err
+		}(seq)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Propose: %v", err)
+		}
+	}
+	if n := atomic.LoadInt64(&pxa[0].peakFlight); n > 2 {
+		t.Fatalf("%v proposals ran at once, limit 2", n)
+	}
+	// Propose() can return before its proposer has finished.
+	for iters := 0; iters < 50 && atomic.LoadInt64(&pxa[0].inFlight) != 0; iters++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&pxa[0].inFlight); n != 0 {
+		t.Fatalf("%v slots still held", n)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestCallErrors(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: callErr reports what went wrong ...\n")
+
+	const npaxos = 1
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	defer cleanup(pxa)
+	pxh := []string{port("callerr", 0)}
+	pxa[0] = Make(pxh, 0, nil)
+
+	check := func(err error, kind error) {
+		if !errors.Is(err, kind) {
+			t.Fatalf("expected %v, got %v", kind, err)
+		}
+		var ce *CallError
+		if !errors.As(err, &ce) || ce.Kind != kind {
+			t.Fatalf("expected a *CallError of kind %v, got %v", kind, err)
+		}
+	}
+
+	// works.
+	var qr QueryReply
+	if err := callErr(pxh[0], "Paxos.Query", &QueryArgs{Seq: 0}, &qr); err != nil {
+		t.Fatalf("callErr: %v", err)
+	}
+
+	// nobody there.
+	err := callErr(port("callerr", 1), "Paxos.Query", &QueryArgs{}, &qr)
+	check(err, ErrDial)
+
+	// the reply can't be decoded into a string.
+	var s string
+	err = callErr(pxh[0], "Paxos.Query", &QueryArgs{}, &s)
+	check(err, ErrCodec)
+
+	// the handler fails: a Mux has no group 7.
+	mh := port("callerr", 2)
+	mx, err := NewMux(mh)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	defer mx.Close()
+	err = callErr(mh, "Paxos.Query", &QueryArgs{GroupID: 7}, &qr)
+	check(err, ErrHandler)
+
+	// the server hangs up without replying.
+	dh := port("callerr", 3)
+	os.Remove(dh)
+	l, err := net.Listen("unix", dh)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	err = callErr(dh, "Paxos.Query", &QueryArgs{}, &qr)
+	check(err, ErrNoReply)
+
+	// call() still says yes or no.
+	if call(port("callerr", 1), "Paxos.Query", &QueryArgs{}, &qr) {
+		t.Fatalf("call() to nobody succeeded")
+	}
+	if !call(pxh[0], "Paxos.Query", &QueryArgs{}, &qr) {
+		t.Fatalf("call() failed")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestInvokeSelf(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: invoke() calls this peer's handlers directly ...\n")
+
+	// no listener, so anything but a direct call would fail.
+	pxh := []string{port("invoke", 0), port("invoke", 1), port("invoke", 2)}
+	px := Make(pxh, 1, rpc.NewServer())
+	defer px.Kill()
+
+	pa := PrepareArgs{Seq: 3, PNum: "10-1"}
+	var pr PrepareReply
+	if !px.invoke(1, "Paxos.Prepare", &pa, &pr) || pr.Err != OK {
+		t.Fatalf("self Prepare failed: %v", pr.Err)
+	}
+	aa := AcceptArgs{Seq: 3, PNum: "10-1", Value: "v"}
+	var ar AcceptReply
+	if !px.invoke(1, "Paxos.Accept", &aa, &ar) || ar.Err != OK {
+		t.Fatalf("self Accept failed: %v", ar.Err)
+	}
+	da := DecideArgs{Seq: 3, PNum: "10-1", Value: "v", Me: 1, Done: -1}
+	var dr DecideReply
+	if !px.invoke(1, "Paxos.Decide", &da, &dr) {
+		t.Fatalf("self Decide failed")
+	}
+	qa := QueryArgs{Seq: 3}
+	var qr QueryReply
+	if !px.invoke(1, "Paxos.Query", &qa, &qr) || qr.State != Decided || qr.Value != "v" {
+		t.Fatalf("self Query returned %v %v", qr.State, qr.Value)
+	}
+
+	if px.invoke(1, "Paxos.Nonesuch", &qa, &qr) {
+		t.Fatalf("self call of a missing method succeeded")
+	}
+	// other peers still go over the network, where nobody is listening.
+	if px.invoke(0, "Paxos.Query", &qa, &qr) {
+		t.Fatalf("invoke() reached a peer that isn't there")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestStartWithPNum(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("withpnum", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	fmt.Printf("Test: StartWithPNum preempts a lower proposal ...\n")
+
+	// a stuck proposer has gathered promises for a pnum far
+	// higher than any clock-generated one.
+	stuck := "900000000000000000000-0"
+	for i := 0; i < npaxos; i++ {
+		var reply PrepareReply
+		if !call(pxh[i], "Paxos.Prepare", &PrepareArgs{Seq: 0, PNum: stuck}, &reply) || reply.Err != OK {
+			t.Fatalf("stuck proposer's Prepare to %v failed", i)
+		}
+	}
+
+	pxa[1].StartWithPNum(0, "900000000000000000001-1", "forced")
+	waitn(t, pxa, 0, npaxos)
+	for i := 0; i < npaxos; i++ {
+		if _, v := pxa[i].Status(0); v != "forced" {
+			t.Fatalf("peer %v decided %v; expected forced", i, v)
+		}
+	}
+
+	// the stuck proposer can no longer get its value accepted.
+	for i := 0; i < npaxos; i++ {
+		var reply AcceptReply
+		call(pxh[i], "Paxos.Accept", &AcceptArgs{Seq: 0, PNum: stuck, Value: "stuck"}, &reply)
+		if reply.Err == OK {
+			t.Fatalf("peer %v accepted the preempted proposal", i)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestLeader(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	fc := newFakeClock()
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("leader", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, Config{Clock: fc})
+	}
+
+	fmt.Printf("Test: One peer establishes a leader lease ...\n")
+
+	for i := 0; i < npaxos; i++ {
+		if pxa[i].Leader() != -1 || pxa[i].IsLeader() {
+			t.Fatalf("peer %v thinks there's a leader before any lease", i)
+		}
+	}
+
+	if !pxa[0].AcquireLease(2 * time.Second) {
+		t.Fatalf("AcquireLease failed")
+	}
+	if !pxa[0].IsLeader() {
+		t.Fatalf("leaseholder doesn't think it's leader")
+	}
+	for i := 0; i < npaxos; i++ {
+		if pxa[i].Leader() != 0 {
+			t.Fatalf("peer %v thinks leader is %v", i, pxa[i].Leader())
+		}
+		if i != 0 && pxa[i].IsLeader() {
+			t.Fatalf("follower %v thinks it's leader", i)
+		}
+	}
+
+	// others can't grab the lease, or prepare, while it lasts.
+	if pxa[1].AcquireLease(2 * time.Second) {
+		t.Fatalf("second peer acquired a held lease")
+	}
+	var reply PrepareReply
+	pxa[2].Prepare(&PrepareArgs{Seq: 0, PNum: freshPNum(pxa[1])}, &reply)
+	if reply.Err != Reject {
+		t.Fatalf("grantor accepted a Prepare from a non-leader")
+	}
+
+	// the leader's own proposals go through.
+	pxa[0].Start(0, "led")
+	waitn(t, pxa, 0, npaxos)
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: A follower's proposals fail fast while the lease is held ...\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := pxa[1].Propose(ctx, 1, "follower"); err != ErrNotLeader {
+		t.Fatalf("follower's Propose() = %v, expected ErrNotLeader", err)
+	}
+	pxa[2].Start(1, "follower")
+	pxa[2].mu.Lock()
+	running := len(pxa[2].proposals)
+	pxa[2].mu.Unlock()
+	if running != 0 {
+		t.Fatalf("follower's Start() left %v proposals running", running)
+	}
+	if fate, _ := pxa[0].Status(1); fate != Pending {
+		t.Fatalf("a follower's proposal was decided under the lease: %v", fate)
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: Leader lease expires ...\n")
+
+	fc.Advance(3 * time.Second)
+	for i := 0; i < npaxos; i++ {
+		if pxa[i].Leader() != -1 {
+			t.Fatalf("peer %v still thinks leader is %v", i, pxa[i].Leader())
+		}
+	}
+	if !pxa[1].AcquireLease(2 * time.Second) {
+		t.Fatalf("AcquireLease failed after the old lease expired")
+	}
+	if !pxa[1].IsLeader() || pxa[0].IsLeader() || pxa[0].Leader() != 1 {
+		t.Fatalf("leadership didn't move to peer 1")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestMaxRounds(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Proposer gives up after MaxRounds ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("maxrounds", i)
+	}
+	// peers 1 and 2 never start.
+	pxa[0], _ = MakeWithConfig(pxh, 0, nil, Config{MaxRounds: 5})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := pxa[0].Propose(ctx, 0, "x"); err != ErrMaxRounds {
+		t.Fatalf("expected ErrMaxRounds, got %v", err)
+	}
+	if fate, _ := pxa[0].Status(0); fate != Pending {
+		t.Fatalf("instance should stay Pending, is %v", fate)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestStateTransitions(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Illegal instance state transitions are refused ...\n")
+
+	px := Make([]string{port("states", 0)}, 0, rpc.NewServer())
+	defer px.Kill()
+
+	px.mu.Lock()
+	if !px.setState(0, Pending) || !px.setState(0, Decided) || !px.setState(0, Decided) {
+		px.mu.Unlock()
+		t.Fatalf("legal transitions refused")
+	}
+	if px.setState(0, Pending) {
+		px.mu.Unlock()
+		t.Fatalf("Decided -> Pending allowed")
+	}
+	px.mu.Unlock()
+	if fate, _ := px.Status(0); fate != Decided {
+		t.Fatalf("instance 0 is %v after refused transition", fate)
+	}
+
+	px.Done(0)
+	if px.Min() != 1 {
+		t.Fatalf("Min() didn't advance")
+	}
+	px.mu.Lock()
+	if px.setState(0, Decided) || px.setState(0, Pending) {
+		px.mu.Unlock()
+		t.Fatalf("transition out of Forgotten allowed")
+	}
+	px.mu.Unlock()
+
+	// a stale Decide doesn't bring a forgotten instance back.
+	px.Decide(&DecideArgs{Seq: 0, Value: "x", PNum: "1-0", Me: 0, Done: 0}, &DecideReply{})
+	px.mu.Lock()
+	_, exist := px.instances[0]
+	px.mu.Unlock()
+	if exist {
+		t.Fatalf("Decide resurrected a forgotten instance")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestLatencies(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Latencies single out a slow peer ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("latency", i)
+	}
+	for i := 0; i < 2; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	// peer 2 sits on every connection for a while.
+	rpcs := rpc.NewServer()
+	pxa[2] = Make(pxh, 2, rpcs)
+	os.Remove(pxh[2])
+	l, err := net.Listen("unix", pxh[2])
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				rpcs.ServeConn(conn)
+			}()
+		}
+	}()
+
+	for seq := 0; seq < 5; seq++ {
+		pxa[0].Start(seq, seq)
+		waitn(t, pxa, seq, npaxos)
+	}
+
+	stats := pxa[0].Latencies()
+	fast, slow := stats["1/Paxos.Prepare"], stats["2/Paxos.Prepare"]
+	if fast.Count == 0 || slow.Count == 0 {
+		t.Fatalf("missing latency stats: %v", stats)
+	}
+	if slow.Mean < 20*time.Millisecond || slow.Mean <= fast.Mean || slow.P99 <= fast.P99 {
+		t.Fatalf("slow peer doesn't stand out: fast %+v, slow %+v", fast, slow)
+	}
+	if _, ok := stats["0/Paxos.Prepare"]; ok {
+		t.Fatalf("self calls shouldn't be timed")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestStatusEx(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: StatusEx tells unknown from pending ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("statusex", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	if fate, known, _ := pxa[0].StatusEx(5); fate != Pending || known {
+		t.Fatalf("never-seen instance: %v, known=%v", fate, known)
+	}
+
+	// a Prepare makes the instance known, but not decided.
+	var reply PrepareReply
+	pxa[0].Prepare(&PrepareArgs{Seq: 5, PNum: "1-1"}, &reply)
+	if fate, known, _ := pxa[0].StatusEx(5); fate != Pending || !known {
+		t.Fatalf("prepared instance: %v, known=%v", fate, known)
+	}
+	if fate, _ := pxa[0].Status(5); fate != Pending {
+		t.Fatalf("Status changed: %v", fate)
+	}
+
+	pxa[1].Start(5, "five")
+	waitn(t, pxa, 5, npaxos)
+	if fate, known, v := pxa[0].StatusEx(5); fate != Decided || !known || v != "five" {
+		t.Fatalf("decided instance: %v, known=%v, %v", fate, known, v)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestPause(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("pause", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	fmt.Printf("Test: Agreement continues with one peer paused ...\n")
+
+	pxa[2].Pause()
+	var reply PrepareReply
+	pxa[2].Prepare(&PrepareArgs{Seq: 0, PNum: "1-0"}, &reply)
+	if reply.Err != Reject {
+		t.Fatalf("paused peer granted a Prepare")
+	}
+
+	pxa[0].Start(0, "a")
+	waitn(t, pxa, 0, npaxos) // the paused peer still learns it
+	pxa[2].Start(1, "b")
+	waitn(t, pxa, 1, npaxos)
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: No agreement with a majority paused ...\n")
+
+	pxa[1].Pause()
+	pxa[0].Start(2, "c")
+	time.Sleep(500 * time.Millisecond)
+	if nd := ndecided(t, pxa, 2); nd != 0 {
+		t.Fatalf("%v peers decided with a majority paused", nd)
+	}
+
+	pxa[1].Resume()
+	pxa[2].Resume()
+	waitn(t, pxa, 2, npaxos)
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestStartUnique(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	fc := newFakeClock()
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("unique", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, Config{Clock: fc, UniqueTTL: time.Minute, UniqueKeys: 2})
+	}
+
+	fmt.Printf("Test: StartUnique retries don't duplicate entries ...\n")
+
+	seq1, err := pxa[0].StartUnique("k1", "v1")
+	if err != nil {
+		t.Fatalf("StartUnique: %v", err)
+	}
+	seq2, err := pxa[0].StartUnique("k1", "v1")
+	if err != nil || seq2 != seq1 {
+		t.Fatalf("retry got seq %v, %v; expected %v", seq2, err, seq1)
+	}
+	waitn(t, pxa, seq1, npaxos)
+	seq3, _ := pxa[0].StartUnique("k2", "v2")
+	if seq3 == seq1 {
+		t.Fatalf("different keys got the same seq %v", seq1)
+	}
+	waitn(t, pxa, seq3, npaxos)
+	if seq, _ := pxa[0].StartUnique("k1", "v1"); seq != seq1 {
+		t.Fatalf("retry after agreement got seq %v; expected %v", seq, seq1)
+	}
+
+	n := 0
+	for seq := 0; seq <= pxa[0].Max(); seq++ {
+		if _, v := pxa[0].Status(seq); v == "v1" {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Fatalf("v1 appears %v times in the log", n)
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: StartUnique forgets old keys ...\n")
+
+	// too many keys: k1 is the oldest, so it goes.
+	seq4, _ := pxa[0].StartUnique("k3", "v3")
+	waitn(t, pxa, seq4, npaxos)
+	if seq, _ := pxa[0].StartUnique("k2", "v2"); seq != seq3 {
+		t.Fatalf("k2 forgotten too soon")
+	}
+	if seq, _ := pxa[0].StartUnique("k1", "v1"); seq == seq1 {
+		t.Fatalf("k1 not forgotten when over UniqueKeys")
+	}
+
+	// too old.
+	fc.Advance(2 * time.Minute)
+	if seq, _ := pxa[0].StartUnique("k3", "v3"); seq == seq4 {
+		t.Fatalf("k3 not forgotten after UniqueTTL")
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: StartUnique forgets keys that fail to start ...\n")
+
+	px := loosePeer(t, "uniquefail", 3, 0, Config{MaxValueBytes: 10})
+	for i := 0; i < 3; i++ {
+		if _, err := px.StartUnique("big", randstring(100)); err == nil {
+			t.Fatalf("StartUnique of an oversized value succeeded")
+		}
+	}
+	px.mu.Lock()
+	nkeys, nuniques := len(px.uniqueKeys), len(px.uniques)
+	px.mu.Unlock()
+	if nkeys != 0 || nuniques != 0 {
+		t.Fatalf("%v keys, %v uniques left after failed starts", nkeys, nuniques)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestOnMinAdvance(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: OnMinAdvance reports Min() going up ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	mins := make(chan int, 100)
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("onmin", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		cfg := Config{}
+		if i == 0 {
+			cfg.OnMinAdvance = func(newMin int) { mins <- newMin }
+		}
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, cfg)
+	}
+
+	for seq := 0; seq < 5; seq++ {
+		pxa[0].Start(seq, seq)
+		waitn(t, pxa, seq, npaxos)
+	}
+	select {
+	case m := <-mins:
+		t.Fatalf("OnMinAdvance(%v) before anyone called Done()", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Done(2)
+	}
+	// the others' Done()s reach peer 0 on their Decides.
+	pxa[1].Start(5, 5)
+	waitn(t, pxa, 5, npaxos)
+	pxa[2].Start(6, 6)
+	waitn(t, pxa, 6, npaxos)
+
+	last := 0
+	for last != 3 {
+		select {
+		case m := <-mins:
+			if m <= last {
+				t.Fatalf("OnMinAdvance(%v) after %v", m, last)
+			}
+			last = m
+		case <-time.After(2 * time.Second):
+			t.Fatalf("OnMinAdvance never reported Min 3; last %v", last)
+		}
+	}
+	if m := pxa[0].Min(); m != 3 {
+		t.Fatalf("Min() %v", m)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestCatchUp(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: CatchUp merges partial logs from several peers ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("catchup", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	// peer 0 knows 0..149, peer 1 knows 100..249, as if
+	// peer 1 had forgotten the start and peer 0 had missed
+	// the end. peer 2 knows nothing. that's several batches.
+	const n = 250
+	decide := func(px *Paxos, seq int) {
+		px.Decide(&DecideArgs{Seq: seq, Value: seq * 10, PNum: "1-0", Me: 0, Done: -1}, &DecideReply{})
+	}
+	for seq := 0; seq < 150; seq++ {
+		decide(pxa[0], seq)
+	}
+	for seq := 100; seq < n; seq++ {
+		decide(pxa[1], seq)
+	}
+
+	if learned := pxa[2].CatchUp(); learned != n {
+		t.Fatalf("CatchUp learned %v instances; expected %v", learned, n)
+	}
+	for seq := 0; seq < n; seq++ {
+		if fate, v := pxa[2].Status(seq); fate != Decided || v != seq*10 {
+			t.Fatalf("seq %v after CatchUp: %v %v", seq, fate, v)
+		}
+	}
+
+	// nothing more to learn.
+	if learned := pxa[2].CatchUp(); learned != 0 {
+		t.Fatalf("second CatchUp learned %v", learned)
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: CatchUp doesn't wait for a slow peer once a batch is filled ...\n")
+
+	var slow []*Paxos = make([]*Paxos, npaxos)
+	defer cleanup(slow)
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("catchupslow", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		slow[i] = Make(pxh, i, nil)
+	}
+	const m = 2*catchUpBatch + 1
+	for seq := 0; seq < m; seq++ {
+		decide(slow[0], seq)
+		decide(slow[1], seq)
+	}
+
+	// peer 1 is stuck. peer 0 fills each batch but the last,
+	// which could go on past what peer 0 knows.
+	slow[1].mu.Lock()
+	progressed := make(chan int, 3)
+	caughtUp := make(chan int)
+	go func() {
+		learned, _ := slow[2].CatchUpContext(context.Background(), func(done, total int) {
+			progressed <- done
+		})
+		caughtUp <- learned
+	}()
+	for b := 1; b <= 2; b++ {
+		select {
+		case done := <-progressed:
+			if done != b*catchUpBatch {
+				slow[1].mu.Unlock()
+				t.Fatalf("progress %v after batch %v", done, b)
+			}
+		case <-time.After(5 * time.Second):
+			slow[1].mu.Unlock()
+			t.Fatalf("CatchUp waited for the slow peer in batch %v", b)
+		}
+	}
+	slow[1].mu.Unlock()
+	if learned := <-caughtUp; learned != m {
+		t.Fatalf("CatchUp learned %v instances; expected %v", learned, m)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestInstallDecided(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: InstallDecided primes a fresh peer ...\n")
+
+	var pxa []*Paxos = make([]*Paxos, 1)
+	defer cleanup(pxa)
+	pxa[0] = Make([]string{port("install", 0)}, 0, nil)
+	px := pxa[0]
+
+	entries := map[int]DecidedValue{}
+	for seq := 0; seq < 5; seq++ {
+		entries[seq] = DecidedValue{PNum: fmt.Sprintf("%v-1", seq+1), V: seq * 10}
+	}
+	px.InstallDecided(entries)
+
+	for seq := 0; seq < 5; seq++ {
+		if fate, v := px.Status(seq); fate != Decided || v != seq*10 {
+			t.Fatalf("Status(%v) = %v, %v", seq, fate, v)
+		}
+		if n, _, ok := px.AcceptedValue(seq); !ok || n != entries[seq].PNum {
+			t.Fatalf("AcceptedValue(%v) pnum %v", seq, n)
+		}
+	}
+	if px.Max() != 4 {
+		t.Fatalf("Max() = %v, want 4", px.Max())
+	}
+	if px.Min() != 0 {
+		t.Fatalf("Min() = %v; InstallDecided shouldn't move Done", px.Min())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := px.Stream(ctx, 0)
+	for seq := 0; seq < 5; seq++ {
+		e := <-ch
+		if e.Seq != seq || e.Err != nil || e.Value != seq*10 {
+			t.Fatalf("Stream entry %+v, want seq %v", e, seq)
+		}
+	}
+
+	// the peer carries on from there as usual.
+	px.Start(5, 50)
+	waitn(t, pxa, 5, 1)
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestCatchUpCancel(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: CatchUpContext reports progress and can be cancelled ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("catchupcancel", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	const n = 1000
+	for seq := 0; seq < n; seq++ {
+		pxa[0].Decide(&DecideArgs{Seq: seq, Value: seq, PNum: "1-0", Me: 0, Done: -1}, &DecideReply{})
+	}
+
+	// stop after the third batch.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls [][2]int
+	learned, err := pxa[2].CatchUpContext(ctx, func(done, total int) {
+		calls = append(calls, [2]int{done, total})
+		if len(calls) == 3 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("CatchUpContext returned %v; expected Canceled", err)
+	}
+	if len(calls) != 3 {
+		t.Fatalf("progress called %v times: %v", len(calls), calls)
+	}
+	for i, c := range calls {
+		if c[0] != (i+1)*catchUpBatch || c[1] != n {
+			t.Fatalf("progress call %v was %v/%v", i, c[0], c[1])
+		}
+	}
+	if learned != 3*catchUpBatch {
+		t.Fatalf("learned %v before cancelling", learned)
+	}
+	if fate, _ := pxa[2].Status(3*catchUpBatch - 1); fate != Decided {
+		t.Fatalf("lost what was learned before cancelling")
+	}
+
+	// and a fresh catch-up picks up the rest.
+	learned, err = pxa[2].CatchUpContext(context.Background(), nil)
+	if err != nil || learned != n-3*catchUpBatch {
+		t.Fatalf("second CatchUpContext: %v, %v", learned, err)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestReset(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Reset forgets everything but keeps serving ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("reset", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	for seq := 0; seq < 3; seq++ {
+		pxa[0].Start(seq, "before")
+		waitn(t, pxa, seq, npaxos)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Done(0)
+	}
+
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Reset()
+	}
+	for i := 0; i < npaxos; i++ {
+		if m := pxa[i].Max(); m != -1 {
+			t.Fatalf("Max() %v after Reset", m)
+		}
+		if m := pxa[i].Min(); m != 0 {
+			t.Fatalf("Min() %v after Reset", m)
+		}
+		for seq := 0; seq < 3; seq++ {
+			if fate, v := pxa[i].Status(seq); fate != Pending {
+				t.Fatalf("seq %v still %v %v after Reset", seq, fate, v)
+			}
+		}
+	}
+
+	// same peers, same sockets, fresh agreement.
+	pxa[1].Start(0, "after")
+	waitn(t, pxa, 0, npaxos)
+	for i := 0; i < npaxos; i++ {
+		if _, v := pxa[i].Status(0); v != "after" {
+			t.Fatalf("decided %v after Reset; expected after", v)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestSinglePeer(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: A single peer agrees with itself ...\n")
+
+	if _, err := MakeWithConfig(nil, 0, nil, Config{}); err != ErrNoPeers {
+		t.Fatalf("Make with no peers: %v, expected ErrNoPeers", err)
+	}
+	if _, err := MakeWithConfig([]string{port("single", 0)}, 1, nil, Config{}); err == nil {
+		t.Fatalf("Make with me out of range succeeded")
+	}
+
+	var pxa []*Paxos = make([]*Paxos, 1)
+	defer cleanup(pxa)
+	pxa[0] = Make([]string{port("single", 0)}, 0, nil)
+
+	for seq := 0; seq < 5; seq++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		v, err := pxa[0].Propose(ctx, seq, seq*10)
+		cancel()
+		if err != nil || v != seq*10 {
+			t.Fatalf("Propose(%v): %v %v", seq, v, err)
+		}
+	}
+	if n := atomic.LoadInt32(&pxa[0].rpcCount); n != 0 {
+		t.Fatalf("single peer served %v RPCs; expected none", n)
+	}
+
+	pxa[0].Done(2)
+	if m := pxa[0].Min(); m != 3 {
+		t.Fatalf("Min() %v after Done(2); expected 3", m)
+	}
+	if d := pxa[0].DoneVector(); len(d) != 1 || d[0] != 2 {
+		t.Fatalf("DoneVector() %v; expected [2]", d)
+	}
+	if fate, _ := pxa[0].Status(1); fate != Forgotten {
+		t.Fatalf("seq 1 %v after Done(2); expected Forgotten", fate)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestCompress(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Large values are compressed on the wire ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("compress", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		px, err := MakeWithConfig(pxh, i, nil, Config{CompressAbove: 1024})
+		if err != nil {
+			t.Fatalf("MakeWithConfig: %v", err)
+		}
+		pxa[i] = px
+	}
+
+	big := strings.Repeat("paxos ", 100000)
+	packed, ok := pxa[0].packValue(big).(packedValue)
+	if !ok {
+		t.Fatalf("%v-byte value not packed", len(big))
+	}
+	if len(packed.Gzip) >= len(big)/10 {
+		t.Fatalf("%v-byte value packed to %v bytes", len(big), len(packed.Gzip))
+	}
+	if pxa[0].packValue("small") != "small" {
+		t.Fatalf("small value packed")
+	}
+
+	pxa[0].Start(0, big)
+	waitn(t, pxa, 0, npaxos)
+	for i := 0; i < npaxos; i++ {
+		if _, v := pxa[i].Status(0); v != big {
+			t.Fatalf("peer %v decided a %T, not the big value", i, v)
+		}
+	}
+
+	// values that were never packed pass through.
+	if unpackValue(77) != 77 || unpackValue(nil) != nil {
+		t.Fatalf("unpackValue changed an unpacked value")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestPeers(t *testing.T) {
+	fmt.Printf("Test: NPeers, Me and Peers match Make ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("peers", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	for i := 0; i < npaxos; i++ {
+		if n := pxa[i].NPeers(); n != npaxos {
+			t.Fatalf("NPeers() %v; expected %v", n, npaxos)
+		}
+		if me := pxa[i].Me(); me != i {
+			t.Fatalf("Me() %v; expected %v", me, i)
+		}
+		peers := pxa[i].Peers()
+		if len(peers) != npaxos {
+			t.Fatalf("Peers() %v; expected %v", peers, pxh)
+		}
+		for j := range peers {
+			if peers[j] != pxh[j] {
+				t.Fatalf("Peers() %v; expected %v", peers, pxh)
+			}
+		}
+
+		// it's a copy.
+		peers[0] = "elsewhere"
+		if pxa[i].Peers()[0] != pxh[0] {
+			t.Fatalf("changing Peers()' result changed the peer")
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestRetrySamePNum(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Lost messages don't bump the pnum ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("samepnum", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	promised := func() string {
+		pxa[0].mu.Lock()
+		defer pxa[0].mu.Unlock()
+		if instance, ok := pxa[0].instances[0]; ok {
+			return instance.n_p
+		}
+		return ""
+	}
+
+	// peers 1 and 2 can't be reached, but nobody competes.
+	for i := 1; i < npaxos; i++ {
+		os.Rename(pxh[i], pxh[i]+"-away")
+	}
+	pxa[0].Start(0, "lonely")
+	time.Sleep(100 * time.Millisecond)
+	first := promised()
+	time.Sleep(300 * time.Millisecond)
+	if p := promised(); p == "" || p != first {
+		t.Fatalf("proposer moved from pnum %v to %v with no competition", first, p)
+	}
+
+	for i := 1; i < npaxos; i++ {
+		os.Rename(pxh[i]+"-away", pxh[i])
+	}
+	waitn(t, pxa, 0, npaxos)
+	if p := promised(); p != first {
+		t.Fatalf("decided with pnum %v; expected the first, %v", p, first)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestPersistDone(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: A peer's own Done survives a restart ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	dir := "/var/tmp/824-" + strconv.Itoa(os.Getuid()) + "/persist-" + strconv.Itoa(os.Getpid())
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	persisters := make([]*FilePersister, npaxos)
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("persist", i)
+		persisters[i] = &FilePersister{Dir: dir + "/" + strconv.Itoa(i)}
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, Config{Persister: persisters[i]})
+	}
+
+	for seq := 0; seq < 5; seq++ {
+		pxa[0].Start(seq, seq)
+		waitn(t, pxa, seq, npaxos)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Done(3)
+	}
+
+	// restart peer 1 with the same Persister.
+	pxa[1].Kill()
+	px, err := MakeWithConfig(pxh, 1, nil, Config{Persister: persisters[1]})
+	if err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	pxa[1] = px
+	if d := pxa[1].DoneVector(); d[1] != 3 {
+		t.Fatalf("restarted peer's DoneVector() %v; expected its own Done 3", d)
+	}
+
+	// the others' Done values come back with the next Decide.
+	pxa[0].Start(5, 5)
+	waitn(t, pxa, 5, npaxos)
+	pxa[2].Start(6, 6)
+	waitn(t, pxa, 6, npaxos)
+	if m := pxa[1].Min(); m != 4 {
+		t.Fatalf("restarted peer's Min() %v; expected 4", m)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestWAL(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	dir := "/var/tmp/824-" + strconv.Itoa(os.Getuid()) + "/wal-" + strconv.Itoa(os.Getpid())
+	os.RemoveAll(dir)
+	os.MkdirAll(dir, 0700)
+	defer os.RemoveAll(dir)
+
+	fmt.Printf("Test: WAL replays the acceptor's state after a crash ...\n")
+
+	path := dir + "/acceptor"
+	restart := func(old *Paxos) *Paxos {
+		if old != nil {
+			old.Kill()
+		}
+		w, err := OpenWAL(path)
+		if err != nil {
+			t.Fatalf("OpenWAL: %v", err)
+		}
+		return loosePeer(t, "wal", 3, 0, Config{WAL: w})
+	}
+
+	px := restart(nil)
+	px.Prepare(&PrepareArgs{Seq: 1, PNum: "5-1"}, &PrepareReply{})
+	px.Accept(&AcceptArgs{Seq: 1, PNum: "5-1", Value: "one"}, &AcceptReply{})
+	px.Decide(&DecideArgs{Seq: 2, Value: "two", PNum: "6-2", Me: 2, Done: -1}, &DecideReply{})
+	px.Prepare(&PrepareArgs{Seq: 3, PNum: "9-2"}, &PrepareReply{})
+
+	// crash halfway through appending another record.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	f.Write([]byte{0, 0, 0, 50, 1, 2, 3})
+	f.Close()
+
+	px = restart(px)
+	if n, v, ok := px.AcceptedValue(1); !ok || n != "5-1" || v != "one" {
+		t.Fatalf("replayed accept: %v, %v, %v", n, v, ok)
+	}
+	if fate, v := px.Status(2); fate != Decided || v != "two" {
+		t.Fatalf("replayed decision: %v, %v", fate, v)
+	}
+	if n, ok := px.PromisedPNum(3); !ok || n != "9-2" {
+		t.Fatalf("replayed promise: %v, %v", n, ok)
+	}
+	if px.Max() != 3 {
+		t.Fatalf("Max() = %v after replay", px.Max())
+	}
+	var preply PrepareReply
+	px.Prepare(&PrepareArgs{Seq: 3, PNum: "8-1"}, &preply)
+	if preply.Err != Reject {
+		t.Fatalf("restarted peer went back on its promise: %v", preply.Err)
+	}
+
+	// appends after the torn tail are read back too.
+	px.Prepare(&PrepareArgs{Seq: 4, PNum: "10-1"}, &PrepareReply{})
+	px = restart(px)
+	if n, ok := px.PromisedPNum(4); !ok || n != "10-1" {
+		t.Fatalf("promise made after recovery: %v, %v", n, ok)
+	}
+	px.Kill()
+	px.config.WAL.Close()
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: CompactWAL drops forgotten instances ...\n")
+
+	var pxa []*Paxos = make([]*Paxos, 1)
+	defer cleanup(pxa)
+	path = dir + "/compact"
+	w, err := OpenWAL(path)
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	pxa[0], _ = MakeWithConfig([]string{port("walcompact", 0)}, 0, nil, Config{WAL: w})
+	for seq := 0; seq < 10; seq++ {
+		pxa[0].Start(seq, seq)
+		waitn(t, pxa, seq, 1)
+	}
+	pxa[0].Done(4)
+	if m := pxa[0].Min(); m != 5 {
+		t.Fatalf("Min() = %v", m)
+	}
+	before, _ := os.Stat(path)
+	if err := pxa[0].CompactWAL(); err != nil {
+		t.Fatalf("CompactWAL: %v", err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Fatalf("WAL didn't shrink: %v -> %v bytes", before.Size(), after.Size())
+	}
+	// still appendable after the rewrite.
+	pxa[0].Start(10, 10)
+	waitn(t, pxa, 10, 1)
+	pxa[0].Kill()
+	w.Close()
+
+	w, err = OpenWAL(path)
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	defer w.Close()
+	seen := map[int]bool{}
+	for _, rec := range w.recovered {
+		if rec.Seq < 5 {
+			t.Fatalf("compacted WAL still has forgotten seq %v", rec.Seq)
+		}
+		seen[rec.Seq] = true
+	}
+	for seq := 5; seq <= 10; seq++ {
+		if !seen[seq] {
+			t.Fatalf("compacted WAL lost live seq %v", seq)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestSpill(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: SpillAbove moves finished instances out of memory ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	dir := "/var/tmp/824-" + strconv.Itoa(os.Getuid()) + "/spill-" + strconv.Itoa(os.Getpid())
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("spill", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		cfg := Config{}
+		if i < 2 {
+			cfg.Persister = &FilePersister{Dir: dir + "/" + strconv.Itoa(i)}
+			cfg.SpillAbove = 2
+		}
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, cfg)
+	}
+
+	const n = 10
+	for seq := 0; seq < n; seq++ {
+		pxa[0].Start(seq, seq*10)
+		waitn(t, pxa, seq, npaxos)
+	}
+	// peer 2 is slow and never calls Done(), so Min() stays put.
+	pxa[0].Done(n - 3)
+	pxa[1].Done(n - 3)
+	for i := 0; i < 2; i++ {
+		if m := pxa[i].Min(); m != 0 {
+			t.Fatalf("peer %v Min() %v", i, m)
+		}
+		pxa[i].mu.Lock()
+		inMemory := len(pxa[i].instances)
+		pxa[i].mu.Unlock()
+		if inMemory != 2 {
+			t.Fatalf("peer %v has %v instances in memory, expected 2", i, inMemory)
+		}
+		for seq := 0; seq < n; seq++ {
+			if fate, v := pxa[i].Status(seq); fate != Decided || v != seq*10 {
+				t.Fatalf("peer %v Status(%v) = %v, %v", i, seq, fate, v)
+			}
+			if _, v, ok := pxa[i].AcceptedValue(seq); !ok || v != seq*10 {
+				t.Fatalf("peer %v AcceptedValue(%v) = %v, %v", i, seq, v, ok)
+			}
+			if fate, v, _ := pxa[i].StaleStatus(seq, time.Hour); fate != Decided || v != seq*10 {
+				t.Fatalf("peer %v StaleStatus(%v) = %v, %v", i, seq, fate, v)
+			}
+		}
+		var sr StatusRangeReply
+		pxa[i].StatusRange(&StatusRangeArgs{From: 0, To: n - 1}, &sr)
+		for seq := 0; seq < n; seq++ {
+			if sr.Fates[seq] != Decided {
+				t.Fatalf("peer %v StatusRange %v is %v", i, seq, sr.Fates[seq])
+			}
+		}
+		if p := pxa[i].RangeProgress(0, n-1); p != 1 {
+			t.Fatalf("peer %v RangeProgress() = %v", i, p)
+		}
+		if s := pxa[i].Snapshot(); s.Decided != n || s.Instances != n || s.Max != n-1 {
+			t.Fatalf("peer %v Snapshot() = %+v", i, s)
+		}
+		if gap := pxa[i].FirstGap(); gap != n {
+			t.Fatalf("peer %v FirstGap() = %v", i, gap)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: a lagging peer catches up from spilled instances ...\n")
+
+	// peer 2 comes back with nothing.
+	pxa[2].Kill()
+	pxa[2], _ = MakeWithConfig(pxh, 2, nil, Config{})
+	if learned := pxa[2].CatchUp(); learned != n {
+		t.Fatalf("CatchUp() learned %v, expected %v", learned, n)
+	}
+	for seq := 0; seq < n; seq++ {
+		if fate, v := pxa[2].Status(seq); fate != Decided || v != seq*10 {
+			t.Fatalf("caught-up Status(%v) = %v, %v", seq, fate, v)
+		}
+	}
+
+	// a Prepare for a spilled instance still sees what was
+	// accepted, and brings it back for good.
+	decides := atomic.LoadInt64(&pxa[0].decides)
+	var reply PrepareReply
+	pxa[0].Prepare(&PrepareArgs{Seq: 3, PNum: "999999999999999999-2"}, &reply)
+	if reply.Err != OK || !reply.HasAccepted || unpackValue(reply.AcceptValue) != 30 {
+		t.Fatalf("Prepare of a spilled instance: %+v", reply)
+	}
+	if data, _ := pxa[0].config.Persister.Load(spillKey(3)); len(data) != 0 {
+		t.Fatalf("instance 3 is back in memory but still in the Persister")
+	}
+	// and it's still the same decision, not a new one.
+	pxa[0].Decide(&DecideArgs{Seq: 3, PNum: reply.AcceptPnum, Value: reply.AcceptValue, Me: 1, Done: -1},
+		&DecideReply{})
+	if d := atomic.LoadInt64(&pxa[0].decides); d != decides {
+		t.Fatalf("re-deciding an unspilled instance counted %v decides", d-decides)
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: a spilled instance that can't be read back is refused ...\n")
+
+	if err := os.WriteFile(dir+"/0/"+spillKey(5), []byte("junk"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	reply = PrepareReply{}
+	pxa[0].Prepare(&PrepareArgs{Seq: 5, PNum: "999999999999999999-2"}, &reply)
+	if reply.Err != Reject {
+		t.Fatalf("Prepare of an unreadable spilled instance: %+v", reply)
+	}
+	var areply AcceptReply
+	pxa[0].Accept(&AcceptArgs{Seq: 5, PNum: "999999999999999999-2", Value: 99}, &areply)
+	if areply.Err != Reject {
+		t.Fatalf("Accept of an unreadable spilled instance: %+v", areply)
+	}
+	pxa[0].Decide(&DecideArgs{Seq: 5, PNum: "999999999999999999-2", Value: 99, Me: 1, Done: -1},
+		&DecideReply{})
+	pxa[0].mu.Lock()
+	_, inMemory := pxa[0].instances[5]
+	spilled := pxa[0].spilled[5]
+	pxa[0].mu.Unlock()
+	if inMemory || !spilled {
+		t.Fatalf("unreadable spilled instance: in memory %v, spilled %v", inMemory, spilled)
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: spilled instances survive CompactWAL and a restart ...\n")
+
+	fp := &FilePersister{Dir: dir + "/wal"}
+	path := dir + "/acceptor"
+	restart := func(old *Paxos) *Paxos {
+		if old != nil {
+			old.Kill()
+			old.config.WAL.Close()
+		}
+		w, err := OpenWAL(path)
+		if err != nil {
+			t.Fatalf("OpenWAL: %v", err)
+		}
+		return loosePeer(t, "spillwal", 3, 0, Config{WAL: w, Persister: fp, SpillAbove: 2})
+	}
+	px := restart(nil)
+	defer func() { px.config.WAL.Close() }()
+	for seq := 0; seq < n; seq++ {
+		px.Decide(&DecideArgs{Seq: seq, Value: seq * 10, PNum: "1-2", Me: 2, Done: -1}, &DecideReply{})
+	}
+	px.Done(n - 1)
+	if err := px.CompactWAL(); err != nil {
+		t.Fatalf("CompactWAL: %v", err)
+	}
+	px = restart(px)
+	px.mu.Lock()
+	nspilled := len(px.spilled)
+	px.mu.Unlock()
+	if nspilled != n {
+		t.Fatalf("restarted peer has %v spilled instances, expected %v", nspilled, n)
+	}
+	for seq := 0; seq < n; seq++ {
+		if fate, v := px.Status(seq); fate != Decided || v != seq*10 {
+			t.Fatalf("restarted Status(%v) = %v, %v", seq, fate, v)
+		}
+	}
+	if m := px.Max(); m != n-1 {
+		t.Fatalf("restarted Max() = %v", m)
+	}
+
+	// the spilled records are in the compacted WAL too.
+	os.RemoveAll(fp.Dir)
+	px = restart(px)
+	for seq := 0; seq < n; seq++ {
+		if fate, v := px.Status(seq); fate != Decided || v != seq*10 {
+			t.Fatalf("Status(%v) = %v, %v from the WAL alone", seq, fate, v)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestDurability(t *testing.T) {
+	fmt.Printf("Test: Durability levels across a crash ...\n")
+
+	dir := "/var/tmp/824-" + strconv.Itoa(os.Getuid()) + "/durability-" + strconv.Itoa(os.Getpid())
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	peers := []string{port("durability", 0), port("durability", 1), port("durability", 2)}
+
+	clock := newFakeClock()
+	restart := func(old *Paxos, fp *FilePersister, d Durability) *Paxos {
+		if old != nil {
+			old.Kill() // a crash: nothing is flushed first
+		}
+		px, err := MakeWithConfig(peers, 0, rpc.NewServer(),
+			Config{Persister: fp, Durability: d, Clock: clock, FlushInterval: time.Second})
+		if err != nil {
+			t.Fatalf("MakeWithConfig: %v", err)
+		}
+		return px
+	}
+
+	// sync mode has Done() on disk before it returns.
+	fp := &FilePersister{Dir: dir + "/sync"}
+	px := restart(nil, fp, DurabilitySync)
+	px.Done(5)
+	px = restart(px, fp, DurabilitySync)
+	if d := px.MyDone(); d != 5 {
+		t.Fatalf("sync: Done() came back as %v, expected 5", d)
+	}
+	px.Kill()
+
+	// buffered mode loses what changed since the last flush.
+	fp = &FilePersister{Dir: dir + "/buffered"}
+	px = restart(nil, fp, DurabilityBuffered)
+	px.Done(5)
+	px = restart(px, fp, DurabilityBuffered)
+	if d := px.MyDone(); d != -1 {
+		t.Fatalf("buffered: unflushed Done() came back as %v", d)
+	}
+	// but keeps what was flushed.
+	px.Done(7)
+	for iters := 0; ; iters++ {
+		clock.Advance(time.Second)
+		if data, _ := fp.Load(doneKey); string(data) == "7" {
+			break
+		}
+		if iters > 100 {
+			t.Fatalf("buffered: Done(7) never flushed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	px = restart(px, fp, DurabilityBuffered)
+	if d := px.MyDone(); d != 7 {
+		t.Fatalf("buffered: flushed Done() came back as %v, expected 7", d)
+	}
+	px.Kill()
+
+	// and none doesn't touch the Persister at all.
+	fp = &FilePersister{Dir: dir + "/none"}
+	px = restart(nil, fp, DurabilityNone)
+	px.Done(5)
+	if data, _ := fp.Load(doneKey); data != nil {
+		t.Fatalf("none: saved %q", data)
+	}
+	px.Kill()
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestWaitForPrefix(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("prefix", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	fmt.Printf("Test: WaitForPrefix waits for the whole prefix ...\n")
+
+	pxa[0].Start(2, "c")
+	pxa[1].Start(0, "a")
+	waitn(t, pxa, 2, npaxos)
+	waitn(t, pxa, 0, npaxos)
+	if g := pxa[2].FirstGap(); g != 1 {
+		t.Fatalf("FirstGap() %v; expected 1", g)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	err := pxa[2].WaitForPrefix(ctx, 2)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Fatalf("WaitForPrefix with a gap returned %v", err)
+	}
+	pxa[2].mu.Lock()
+	n := len(pxa[2].waiters[1])
+	pxa[2].mu.Unlock()
+	if n != 0 {
+		t.Fatalf("%v waiters left on the gap after WaitForPrefix gave up", n)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- pxa[2].WaitForPrefix(context.Background(), 2)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("WaitForPrefix returned %v before the gap was filled", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	pxa[0].Start(1, "b")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WaitForPrefix: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("WaitForPrefix didn't return once the gap was filled")
+	}
+	if g := pxa[2].FirstGap(); g != 3 {
+		t.Fatalf("FirstGap() %v; expected 3", g)
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: WaitForPrefix fills gaps with NoOp ...\n")
+
+	pxa[2].Kill()
+	pxa[2], _ = MakeWithConfig(pxh, 2, nil, Config{FillGapsAfter: 50 * time.Millisecond})
+
+	pxa[0].Start(5, "f")
+	waitn(t, pxa, 5, npaxos)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	err = pxa[2].WaitForPrefix(ctx, 5)
+	cancel()
+	if err != nil {
+		t.Fatalf("WaitForPrefix with filling: %v", err)
+	}
+	for _, seq := range []int{3, 4} {
+		if _, v := pxa[2].Status(seq); v != (NoOp{}) {
+			t.Fatalf("gap %v filled with %v", seq, v)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestLockedHandlers(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Acceptor internals compose under the lock ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("locked", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	// agreement runs through the RPC handlers while this
+	// goroutine drives peer 0's acceptor directly, holding
+	// the lock across a whole prepare and accept.
+	var wg sync.WaitGroup
+	for seq := 0; seq < 10; seq++ {
+		wg.Add(1)
+		go func(seq int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if v, err := pxa[1].Propose(ctx, seq, seq); err != nil || v != seq {
+				t.Errorf("Propose(%v): %v %v", seq, v, err)
+			}
+		}(seq)
+	}
+	for seq := 100; seq < 110; seq++ {
+		px := pxa[0]
+		pnum, _ := px.generatePNum()
+		px.mu.Lock()
+		var preply PrepareReply
+		px.prepareLocked(&PrepareArgs{Seq: seq, PNum: pnum}, &preply)
+		var areply AcceptReply
+		px.acceptLocked(&AcceptArgs{Seq: seq, PNum: pnum, Value: "direct"}, &areply)
+		n_a := px.instances[seq].n_a
+		px.mu.Unlock()
+		if preply.Err != OK || areply.Err != OK || n_a != pnum {
+			t.Fatalf("seq %v: prepare %v, accept %v, n_a %v", seq, preply.Err, areply.Err, n_a)
+		}
+	}
+	wg.Wait()
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestDumpState(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: DumpState describes the peer ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("dump", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	var empty struct{ Max int }
+	if err := json.Unmarshal(pxa[0].DumpState(), &empty); err != nil || empty.Max != -1 {
+		t.Fatalf("empty peer's DumpState() max %v, %v", empty.Max, err)
+	}
+
+	pxa[0].Start(4, "dumped")
+	waitn(t, pxa, 4, npaxos)
+
+	var d struct {
+		Me        int
+		Peers     []string
+		Dones     []int
+		Min       int
+		Max       int
+		Instances []struct {
+			Seq   int
+			State string
+			NA    string `json:"n_a"`
+			Value string
+		}
+	}
+	data := pxa[1].DumpState()
+	if err := json.Unmarshal(data, &d); err != nil {
+		t.Fatalf("DumpState() isn't JSON: %v\n%s", err, data)
+	}
+	if d.Me != 1 || len(d.Peers) != npaxos || len(d.Dones) != npaxos || d.Min != 0 || d.Max != 4 {
+		t.Fatalf("DumpState() header wrong: %s", data)
+	}
+	if len(d.Instances) != 1 {
+		t.Fatalf("DumpState() has %v instances; expected 1: %s", len(d.Instances), data)
+	}
+	in := d.Instances[0]
+	if in.Seq != 4 || in.State != "Decided" || in.NA == "" || in.Value != "dumped" {
+		t.Fatalf("DumpState() instance wrong: %s", data)
+	}
+
+	// Max agrees with Max() even when Done() has gone past it.
+	px := loosePeer(t, "dumpone", 1, 0, Config{})
+	px.Done(9)
+	d.Max = 0
+	data = px.DumpState()
+	if err := json.Unmarshal(data, &d); err != nil || d.Max != -1 {
+		t.Fatalf("DumpState() max %v past Done(): %s", d.Max, data)
+	}
+	if s := px.Snapshot(); s.Max != -1 || px.Max() != -1 {
+		t.Fatalf("Snapshot() max %v, Max() %v past Done()", s.Max, px.Max())
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestDisableUnreliable(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: DisableUnreliable serves every connection ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("reliable", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, Config{DisableUnreliable: true})
+		pxa[i].setunreliable(true)
+	}
+
+	// an unreliable peer would drop or cut off about a
+	// quarter of these.
+	const ncalls = 200
+	for i := 0; i < ncalls; i++ {
+		var reply QueryReply
+		if !call(pxh[0], "Paxos.Query", &QueryArgs{Seq: i}, &reply) {
+			t.Fatalf("call %v failed", i)
+		}
+	}
+	if n := atomic.LoadInt32(&pxa[0].rpcCount); n != ncalls {
+		t.Fatalf("served %v connections; expected %v", n, ncalls)
+	}
+
+	pxa[1].Start(0, "steady")
+	waitn(t, pxa, 0, npaxos)
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestLog(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Concurrent Log appends make one gap-free order ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("log", i)
+	}
+	logs := make([]*Log, npaxos)
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+		logs[i] = NewLog(pxa[i])
+		defer logs[i].Close()
+	}
+
+	// every peer appends the same values, so Append() has
+	// to tell its own from an equal one.
+	const nappends = 10
+	var mu sync.Mutex
+	seqs := map[int]bool{}
+	var wg sync.WaitGroup
+	for i := 0; i < npaxos; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+			for j := 0; j < nappends; j++ {
+				seq, err := logs[i].Append(ctx, j)
+				if err != nil {
+					t.Errorf("Append: %v", err)
+					return
+				}
+				mu.Lock()
+				if seqs[seq] {
+					t.Errorf("seq %v returned by two Appends", seq)
+				}
+				seqs[seq] = true
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	const total = npaxos * nappends
+	for seq := 0; seq < total; seq++ {
+		if !seqs[seq] {
+			t.Fatalf("no Append returned seq %v", seq)
+		}
+	}
+
+	var first []Entry
+	for i := 0; i < npaxos; i++ {
+		ch := logs[i].ReadFrom(0)
+		var got []Entry
+		for len(got) < total {
+			select {
+			case e := <-ch:
+				got = append(got, e)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("peer %v read only %v entries", i, len(got))
+			}
+		}
+		counts := map[interface{}]int{}
+		for j, e := range got {
+			if e.Seq != j {
+				t.Fatalf("peer %v read seq %v at position %v", i, e.Seq, j)
+			}
+			counts[e.Value]++
+			if first != nil && first[j] != e {
+				t.Fatalf("peer %v read %v; peer 0 read %v", i, e, first[j])
+			}
+		}
+		for j := 0; j < nappends; j++ {
+			if counts[j] != npaxos {
+				t.Fatalf("value %v appended %v times; expected %v", j, counts[j], npaxos)
+			}
+		}
+		first = got
+	}
+
+	// readers have Done() what they read.
+	for i := 0; i < npaxos; i++ {
+		start := time.Now()
+		for pxa[i].DoneVector()[i] != total-1 {
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("peer %v Done %v after reading", i, pxa[i].DoneVector()[i])
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestDecideRetry(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: A peer that was down gets the Decide later ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("decideretry", i)
+	}
+	cfg := Config{DecideRetryInterval: 20 * time.Millisecond, DecideRetryMax: 80 * time.Millisecond}
+	for i := 0; i < npaxos; i++ {
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, cfg)
+	}
+
+	os.Rename(pxh[2], pxh[2]+"-away")
+	before := runtime.NumGoroutine()
+	const n = 50
+	for seq := 0; seq < n; seq++ {
+		pxa[0].Start(seq, "late")
+		waitn(t, pxa[:2], seq, 2)
+	}
+
+	// down for several retry intervals.
+	time.Sleep(300 * time.Millisecond)
+	if fate, _ := pxa[2].Status(0); fate != Pending {
+		t.Fatalf("unreachable peer knows seq 0 is %v", fate)
+	}
+	// one retry loop for all the Decides peer 2 missed.
+	if g := runtime.NumGoroutine() - before; g >= n/2 {
+		t.Fatalf("%v more goroutines retrying %v Decides", g, n)
+	}
+
+	os.Rename(pxh[2]+"-away", pxh[2])
+	for seq := 0; seq < n; seq++ {
+		waitn(t, pxa, seq, npaxos)
+		if _, v := pxa[2].Status(seq); v != "late" {
+			t.Fatalf("returning peer decided %v", v)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestSnapshot(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Snapshot is internally consistent ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("snapshot", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	consistent := func(s StateSnapshot) bool {
+		return s.Min <= s.Max+1 && s.Decided+s.Pending == s.Instances && s.Decided >= 0 && s.Pending >= 0
+	}
+	check := func(s StateSnapshot) {
+		if !consistent(s) {
+			t.Fatalf("inconsistent snapshot %+v", s)
+		}
+	}
+
+	if s := pxa[0].Snapshot(); s != (StateSnapshot{Min: 0, Max: -1}) {
+		t.Fatalf("empty peer's snapshot %+v", s)
+	}
+
+	// snapshot while agreement and GC are going on. the
+	// snapshots are taken in another goroutine, so that
+	// waitn() runs on this one.
+	stop := make(chan bool)
+	defer close(stop)
+	bad := make(chan StateSnapshot, 1)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if s := pxa[1].Snapshot(); !consistent(s) {
+				bad <- s
+				return
+			}
+		}
+	}()
+	for seq := 0; seq < 20; seq++ {
+		pxa[seq%npaxos].Start(seq, seq)
+		waitn(t, pxa, seq, npaxos)
+		for i := 0; i < npaxos; i++ {
+			pxa[i].Done(seq - 5)
+		}
+	}
+	select {
+	case s := <-bad:
+		t.Fatalf("inconsistent snapshot %+v", s)
+	default:
+	}
+
+	// a prepared but undecided instance is Pending.
+	pxa[0].Prepare(&PrepareArgs{Seq: 30, PNum: freshPNum(pxa[0])}, &PrepareReply{})
+	s := pxa[0].Snapshot()
+	check(s)
+	if s.Max != 30 || s.Pending != 1 {
+		t.Fatalf("snapshot %+v; expected Max 30 and one Pending", s)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestNilValue(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: An accepted nil survives preemption ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("nilvalue", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	// a proposer got nil accepted by a majority, then died
+	// before sending Decides.
+	pnum, _ := pxa[0].generatePNum()
+	for i := 0; i < 2; i++ {
+		var preply PrepareReply
+		pxa[i].Prepare(&PrepareArgs{Seq: 0, PNum: pnum}, &preply)
+		var areply AcceptReply
+		pxa[i].Accept(&AcceptArgs{Seq: 0, PNum: pnum, Value: nil}, &areply)
+		if preply.Err != OK || areply.Err != OK {
+			t.Fatalf("setting up: prepare %v, accept %v", preply.Err, areply.Err)
+		}
+	}
+
+	// a later proposer must choose nil, not its own value.
+	pxa[2].Start(0, "mine")
+	waitn(t, pxa, 0, npaxos)
+	for i := 0; i < npaxos; i++ {
+		if _, v := pxa[i].Status(0); v != nil {
+			t.Fatalf("peer %v decided %v; expected nil", i, v)
+		}
+	}
+
+	// nil can be proposed outright too.
+	pxa[1].Start(1, nil)
+	waitn(t, pxa, 1, npaxos)
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestPendingSeqs(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: PendingSeqs lists just the undecided instances ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("pendingseqs", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	if p := pxa[0].PendingSeqs(); len(p) != 0 {
+		t.Fatalf("PendingSeqs() %v on an empty peer", p)
+	}
+
+	// decided: 0, 2, 4. pending (prepared only): 1, 5, 9,
+	// inserted out of order.
+	for _, seq := range []int{0, 2, 4} {
+		pxa[1].Start(seq, seq)
+		waitn(t, pxa, seq, npaxos)
+	}
+	for _, seq := range []int{9, 1, 5} {
+		pxa[0].Prepare(&PrepareArgs{Seq: seq, PNum: freshPNum(pxa[0])}, &PrepareReply{})
+	}
+	if p := fmt.Sprint(pxa[0].PendingSeqs()); p != "[1 5 9]" {
+		t.Fatalf("PendingSeqs() %v; expected [1 5 9]", p)
+	}
+
+	// forgotten ones don't count, even if never decided.
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Done(2)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Start(10+i, i)
+		waitn(t, pxa, 10+i, npaxos)
+	}
+	if m := pxa[0].Min(); m != 3 {
+		t.Fatalf("Min() %v; expected 3", m)
+	}
+	if p := fmt.Sprint(pxa[0].PendingSeqs()); p != "[5 9]" {
+		t.Fatalf("PendingSeqs() %v after Done(2); expected [5 9]", p)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestReuseConns(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: ReuseConns shares connections and reconnects ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("reuse", i)
+	}
+	cfg := Config{ReuseConns: true}
+	for i := 0; i < npaxos; i++ {
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, cfg)
+	}
+
+	for seq := 0; seq < 5; seq++ {
+		pxa[0].Start(seq, seq)
+		waitn(t, pxa, seq, npaxos)
+	}
+	if d := atomic.LoadInt64(&pxa[0].pool.dials); d != npaxos-1 {
+		t.Fatalf("dialed %v times for %v peers", d, npaxos-1)
+	}
+
+	// restart peer 2; peer 0's connection to it is dead now,
+	// and should be replaced, not reused or given up on.
+	pxa[2].Kill()
+	pxa[2], _ = MakeWithConfig(pxh, 2, nil, cfg)
+	pxa[0].Start(5, 5)
+	waitn(t, pxa, 5, npaxos)
+	if d := atomic.LoadInt64(&pxa[0].pool.dials); d != npaxos {
+		t.Fatalf("dialed %v times, expected %v", d, npaxos)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestTransport(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: agreement over an in-memory Transport ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = "mem-" + strconv.Itoa(i)
+	}
+	mem := &MemNetwork{}
+	for i := 0; i < npaxos; i++ {
+		var err error
+		pxa[i], err = MakeWithConfig(pxh, i, nil, Config{Transport: mem.Transport})
+		if err != nil {
+			t.Fatalf("MakeWithConfig: %v", err)
+		}
+	}
+
+	for seq := 0; seq < 3; seq++ {
+		pxa[seq].Start(seq, seq*10)
+		waitn(t, pxa, seq, npaxos)
+	}
+	for i := 0; i < npaxos; i++ {
+		if _, err := os.Stat(pxh[i]); err == nil {
+			t.Fatalf("%v exists; the default transport was used", pxh[i])
+		}
+	}
+
+	// a killed peer drops off the network, and the rest go on.
+	pxa[2].Kill()
+	pxa[0].Start(3, 30)
+	waitmajority(t, pxa, 3)
+	if n := ndecided(t, pxa[2:], 3); n != 0 {
+		t.Fatalf("killed peer decided")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestMemTransportLoss(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: agreement over a lossy MemNetwork ...\n")
+
+	const npaxos = 5
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = "memloss-" + strconv.Itoa(i)
+	}
+	mem := &MemNetwork{}
+	mem.Seed(42)
+	mem.SetLatency(time.Millisecond)
+	mem.SetLoss(0.2)
+	for i := 0; i < npaxos; i++ {
+		var err error
+		pxa[i], err = MakeWithConfig(pxh, i, nil, Config{Transport: mem.Transport})
+		if err != nil {
+			t.Fatalf("MakeWithConfig: %v", err)
+		}
+	}
+
+	// concurrent proposers, some of them duelling.
+	const nseq = 10
+	for seq := 0; seq < nseq; seq++ {
+		pxa[seq%npaxos].Start(seq, seq*10)
+		pxa[(seq+1)%npaxos].Start(seq, seq*10+1)
+	}
+	// with lost Decides, the stragglers need another
+	// proposal to learn the outcome.
+	for seq := 0; seq < nseq; seq++ {
+		waitmajority(t, pxa, seq)
+		for i := 0; i < npaxos; i++ {
+			if fate, _ := pxa[i].Status(seq); fate != Decided {
+				pxa[i].Start(seq, -1)
+			}
+		}
+		waitn(t, pxa, seq, npaxos)
+	}
+	for seq := 0; seq < nseq; seq++ {
+		_, v := pxa[0].Status(seq)
+		if v != seq*10 && v != seq*10+1 {
+			t.Fatalf("seq %v decided %v, which nobody proposed", seq, v)
+		}
+	}
+
+	if n := atomic.LoadInt64(&mem.dropped); n == 0 {
+		t.Fatalf("no calls were lost")
+	}
+	for i := 0; i < npaxos; i++ {
+		if _, err := os.Stat(pxh[i]); err == nil {
+			t.Fatalf("%v exists; sockets were used", pxh[i])
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestResolver(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: RPCs follow the Resolver when a peer moves ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("resolver", i)
+	}
+	moved := port("resolver-moved", 2)
+
+	var mu sync.Mutex
+	addrs := append([]string{}, pxh...)
+	resolver := func(i int) string {
+		mu.Lock()
+		defer mu.Unlock()
+		return addrs[i]
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, Config{Resolver: resolver})
+	}
+
+	pxa[0].Start(0, "here")
+	waitn(t, pxa, 0, npaxos)
+
+	// peer 2 is rescheduled to a new address.
+	pxa[2].Kill()
+	moved2 := append([]string{}, pxh...)
+	moved2[2] = moved
+	pxa[2], _ = MakeWithConfig(moved2, 2, nil, Config{Resolver: resolver})
+	mu.Lock()
+	addrs[2] = moved
+	mu.Unlock()
+
+	pxa[0].Start(1, "there")
+	waitn(t, pxa, 1, npaxos)
+	if _, v := pxa[2].Status(1); v != "there" {
+		t.Fatalf("moved peer decided %v", v)
+	}
+	if n := atomic.LoadInt32(&pxa[2].rpcCount); n == 0 {
+		t.Fatalf("moved peer got no RPCs at its new address")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestLeaseRead(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	fc := newFakeClock()
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("leaseread", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, Config{Clock: fc, MaxClockDrift: 500 * time.Millisecond})
+	}
+
+	fmt.Printf("Test: LeaseRead serves the leader's reads within its lease ...\n")
+
+	if _, _, ok := pxa[0].LeaseRead(0); ok {
+		t.Fatalf("LeaseRead succeeded without a lease")
+	}
+	// before the lease, peer 2 gets "early" accepted for 5
+	// by a majority that leaves out the leader-to-be.
+	pnum := freshPNum(pxa[2])
+	for i := 1; i < npaxos; i++ {
+		pxa[i].Prepare(&PrepareArgs{Seq: 5, PNum: pnum}, &PrepareReply{})
+		pxa[i].Accept(&AcceptArgs{Seq: 5, PNum: pnum, Value: "early"}, &AcceptReply{})
+	}
+	if !pxa[0].AcquireLease(2 * time.Second) {
+		t.Fatalf("AcquireLease failed")
+	}
+	pxa[0].Start(0, "led")
+	waitn(t, pxa, 0, npaxos)
+
+	fate, v, ok := pxa[0].LeaseRead(0)
+	if !ok || fate != Decided || v != "led" {
+		t.Fatalf("leader's LeaseRead: %v, %v, %v", fate, v, ok)
+	}
+	if _, _, ok := pxa[0].LeaseRead(5); ok {
+		t.Fatalf("leader's LeaseRead of an instance decided without it succeeded")
+	}
+	if fate, _, ok := pxa[0].LeaseRead(6); !ok || fate != Pending {
+		t.Fatalf("leader's LeaseRead past what anyone has seen: %v, %v", fate, ok)
+	}
+	// grantors refuse other proposers' Accepts too.
+	var areply AcceptReply
+	pxa[1].Accept(&AcceptArgs{Seq: 5, PNum: pnum, Value: "late"}, &areply)
+	if areply.Err != Reject {
+		t.Fatalf("grantor took another proposer's Accept: %v", areply.Err)
+	}
+	for i := 1; i < npaxos; i++ {
+		if _, _, ok := pxa[i].LeaseRead(0); ok {
+			t.Fatalf("follower %v served a LeaseRead", i)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: LeaseRead fails as the lease runs out ...\n")
+
+	// still leader, but within MaxClockDrift of the end.
+	fc.Advance(1600 * time.Millisecond)
+	if !pxa[0].IsLeader() {
+		t.Fatalf("lease ran out early")
+	}
+	if _, _, ok := pxa[0].LeaseRead(0); ok {
+		t.Fatalf("LeaseRead succeeded within MaxClockDrift of expiry")
+	}
+	fc.Advance(time.Second)
+	if _, _, ok := pxa[0].LeaseRead(0); ok {
+		t.Fatalf("LeaseRead succeeded after the lease expired")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestLeaderFailover(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Jittered takeover after the leader dies ...\n")
+
+	const npaxos = 5
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("failover", i)
+	}
+	cfg := Config{LeaseDuration: 150 * time.Millisecond, LeaderJitter: 250 * time.Millisecond}
+	for i := 0; i < npaxos; i++ {
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, cfg)
+	}
+
+	// wait until every live peer agrees on one leader,
+	// returning it.
+	agreed := func(limit time.Duration) int {
+		start := time.Now()
+		for time.Since(start) < limit {
+			leader := -1
+			for i := 0; i < npaxos; i++ {
+				if pxa[i].isdead() {
+					continue
+				}
+				l := pxa[i].Leader()
+				if l == -1 || (leader != -1 && l != leader) || pxa[l].isdead() {
+					leader = -2
+					break
+				}
+				leader = l
+			}
+			if leader >= 0 {
+				return leader
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("no agreed leader within %v", limit)
+		return -1
+	}
+
+	first := agreed(2 * time.Second)
+
+	// the leader keeps its lease.
+	time.Sleep(500 * time.Millisecond)
+	if l := agreed(time.Second); l != first {
+		t.Fatalf("leadership moved from %v to %v with no failure", first, l)
+	}
+
+	pxa[first].Kill()
+	second := agreed(2 * time.Second)
+	if second == first {
+		t.Fatalf("dead peer %v still leader", first)
+	}
+
+	// and the new one settles rather than duelling.
+	time.Sleep(500 * time.Millisecond)
+	if l := agreed(time.Second); l != second {
+		t.Fatalf("leadership moved from %v to %v after takeover", second, l)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestFailureDetector(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: FailureDetector speeds up leader takeover ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("detector", i)
+	}
+	// a jitter so long that only the detector can explain a
+	// quick takeover.
+	fds := make([]*PhiDetector, npaxos)
+	for i := 0; i < npaxos; i++ {
+		fds[i] = NewPhiDetector()
+		cfg := Config{LeaseDuration: 300 * time.Millisecond, LeaderJitter: 2 * time.Second, FailureDetector: fds[i]}
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, cfg)
+	}
+
+	leader := -1
+	for iters := 0; iters < 100 && leader == -1; iters++ {
+		for i := 0; i < npaxos; i++ {
+			if pxa[i].IsLeader() {
+				leader = i
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if leader == -1 {
+		t.Fatalf("no leader")
+	}
+	// let the followers learn how often the leader renews.
+	time.Sleep(time.Second)
+
+	pxa[leader].Kill()
+	killed := time.Now()
+
+	next := -1
+	for time.Since(killed) < 1500*time.Millisecond && next == -1 {
+		for i := 0; i < npaxos; i++ {
+			if i != leader && pxa[i].IsLeader() {
+				next = i
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if next == -1 {
+		t.Fatalf("no takeover within %v of the leader dying", time.Since(killed))
+	}
+	fmt.Printf("  ... %v took over from %v after %v\n", next, leader, time.Since(killed))
+
+	for i := 0; i < npaxos; i++ {
+		if i == leader {
+			continue
+		}
+		if s := fds[i].Suspicion(leader, time.Now()); s < 1 {
+			t.Fatalf("peer %v's suspicion of dead leader %v is %v", i, leader, s)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+// a FailureDetector that asks its peer who leads whenever
+// it's told anything, so it deadlocks if it's called with
+// px.mu held.
+type reentrantDetector struct {
+	px *Paxos
+}
+
+func (d *reentrantDetector) Heard(peer int, at time.Time)  { d.px.Leader() }
+func (d *reentrantDetector) Missed(peer int, at time.Time) { d.px.Leader() }
+func (d *reentrantDetector) Suspicion(peer int, now time.Time) float64 {
+	return 0
+}
+
+func TestDetectorUnlocked(t *testing.T) {
+	fmt.Printf("Test: a FailureDetector may call back into Paxos ...\n")
+
+	fd := &reentrantDetector{}
+	px := loosePeer(t, "reentrant", 3, 0, Config{FailureDetector: fd})
+	fd.px = px
+
+	done := make(chan bool)
+	go func() {
+		var reply LeaseReply
+		px.Lease(&LeaseArgs{Leader: 1, Duration: time.Second}, &reply)
+		done <- reply.Err == OK
+	}()
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatalf("Lease refused")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Lease deadlocked calling the detector")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestMakeBadMe(t *testing.T) {
+	fmt.Printf("Test: Make rejects me outside peers ...\n")
+
+	pxh := []string{port("badme", 0), port("badme", 1), port("badme", 2)}
+	for _, me := range []int{len(pxh), len(pxh) + 5, -1} {
+		px, err := MakeWithConfig(pxh, me, nil, Config{})
+		if px != nil || err == nil {
+			t.Fatalf("MakeWithConfig with me %v succeeded", me)
+		}
+		if !strings.Contains(err.Error(), "out of range") {
+			t.Fatalf("MakeWithConfig with me %v: unclear error %q", me, err)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestIntercept(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Intercept drops chosen messages ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("intercept", i)
+	}
+	var dropped int32
+	noAccepts := func(method string, args interface{}) bool {
+		if method == "Paxos.Accept" {
+			atomic.AddInt32(&dropped, 1)
+			return false
+		}
+		return true
+	}
+	for i := 0; i < npaxos; i++ {
+		cfg := Config{}
+		if i == 2 {
+			cfg.Intercept = noAccepts
+		}
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, cfg)
+	}
+
+	for seq := 0; seq < 3; seq++ {
+		pxa[seq].Start(seq, seq)
+		waitn(t, pxa, seq, npaxos)
+	}
+	if atomic.LoadInt32(&dropped) == 0 {
+		t.Fatalf("Intercept saw no Accepts")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+// a log destination that's safe to read while peers write it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestHandlerPanic(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: a panicking handler doesn't kill the peer ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("panic", i)
+	}
+	var panics int32
+	boom := func(method string, args interface{}) bool {
+		if a, ok := args.(*AcceptArgs); ok && unpackValue(a.Value) == "boom" {
+			atomic.AddInt32(&panics, 1)
+			panic("boom")
+		}
+		return true
+	}
+	logs := &lockedBuffer{}
+	for i := 0; i < npaxos; i++ {
+		cfg := Config{}
+		if i == 2 {
+			cfg.Intercept = boom
+			cfg.Logger = log.New(logs, "", 0)
+		}
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, cfg)
+	}
+
+	// peer 2 panics on the Accept, but the other two are a
+	// majority, and it still learns the Decide.
+	pxa[0].Start(0, "boom")
+	waitn(t, pxa, 0, npaxos)
+	if atomic.LoadInt32(&panics) == 0 {
+		t.Fatalf("handler never panicked")
+	}
+	if !strings.Contains(logs.String(), "Paxos.Accept panicked: boom") {
+		t.Fatalf("panic wasn't logged; got %q", logs.String())
+	}
+
+	// and it goes on serving, including its own proposals.
+	pxa[2].Start(1, "fine")
+	waitn(t, pxa, 1, npaxos)
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestAppend(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: concurrent Append()s land in distinct instances ...\n")
+
+	const npaxos = 3
+	const nappenders = 3
+	const nappends = 5
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("append", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	landed := map[int]string{}
+	var wg sync.WaitGroup
+	for i := 0; i < npaxos; i++ {
+		for j := 0; j < nappenders; j++ {
+			wg.Add(1)
+			go func(i, j int) {
+				defer wg.Done()
+				for k := 0; k < nappends; k++ {
+					v := fmt.Sprintf("%v-%v-%v", i, j, k)
+					seq, decided, err := pxa[i].Append(ctx, v)
+					if err != nil {
+						t.Errorf("Append(%v): %v", v, err)
+						return
+					}
+					if decided != v {
+						t.Errorf("Append(%v) returned %v", v, decided)
+					}
+					mu.Lock()
+					if other, ok := landed[seq]; ok {
+						t.Errorf("%v and %v both landed at %v", other, v, seq)
+					}
+					landed[seq] = v
+					mu.Unlock()
+				}
+			}(i, j)
+		}
+	}
+	wg.Wait()
+
+	if len(landed) != npaxos*nappenders*nappends {
+		t.Fatalf("%v values landed, expected %v", len(landed), npaxos*nappenders*nappends)
+	}
+	for seq, v := range landed {
+		waitn(t, pxa, seq, npaxos)
+		if _, dv := pxa[0].Status(seq); dv != v {
+			t.Fatalf("instance %v decided %v, Append() said %v", seq, dv, v)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+// a SeqAllocator leasing out blocks of seqs from a counter
+// shared by all the appenders, as a leader might.
+type blockAllocator struct {
+	mu     sync.Mutex
+	shared *int64
+	next   int
+	end    int
+}
+
+const allocBlock = 4
+
+func (a *blockAllocator) Next() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.next == a.end {
+		a.end = int(atomic.AddInt64(a.shared, allocBlock))
+		a.next = a.end - allocBlock
+	}
+	a.next++
+	return a.next - 1
+}
+
+func TestSeqAllocator(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	const npaxos = 3
+	const nappends = 10
+
+	// Append() nappends values from each peer at once,
+	// returning how many tries collided.
+	run := func(tag string, alloc func(i int) SeqAllocator) int64 {
+		var pxa []*Paxos = make([]*Paxos, npaxos)
+		var pxh []string = make([]string, npaxos)
+		defer cleanup(pxa)
+		for i := 0; i < npaxos; i++ {
+			pxh[i] = port(tag, i)
+		}
+		for i := 0; i < npaxos; i++ {
+			pxa[i], _ = MakeWithConfig(pxh, i, nil, Config{SeqAllocator: alloc(i)})
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		var mu sync.Mutex
+		landed := map[int]string{}
+		var wg sync.WaitGroup
+		for i := 0; i < npaxos; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for k := 0; k < nappends; k++ {
+					v := fmt.Sprintf("%v-%v", i, k)
+					seq, _, err := pxa[i].Append(ctx, v)
+					if err != nil {
+						t.Errorf("Append(%v): %v", v, err)
+						return
+					}
+					mu.Lock()
+					if other, ok := landed[seq]; ok {
+						t.Errorf("%v and %v both landed at %v", other, v, seq)
+					}
+					landed[seq] = v
+					mu.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+		if len(landed) != npaxos*nappends {
+			t.Fatalf("%v values landed, expected %v", len(landed), npaxos*nappends)
+		}
+		var collisions int64
+		for i := 0; i < npaxos; i++ {
+			collisions += atomic.LoadInt64(&pxa[i].collisions)
+		}
+		return collisions
+	}
+
+	fmt.Printf("Test: a SeqAllocator that leases out ranges avoids collisions ...\n")
+
+	byMax := run("allocmax", func(i int) SeqAllocator { return nil })
+	var shared int64
+	byBlock := run("allocblock", func(i int) SeqAllocator { return &blockAllocator{shared: &shared} })
+	if byBlock != 0 {
+		t.Fatalf("%v collisions with disjoint ranges", byBlock)
+	}
+	fmt.Printf("  ... %v collisions by default, %v with ranges\n", byMax, byBlock)
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestBackoffSeed(t *testing.T) {
+	fmt.Printf("Test: backoff RNG seeding ...\n")
+
+	delays := func(px *Paxos) []time.Duration {
+		d := make([]time.Duration, 10)
+		for i := range d {
+			d[i] = px.randDuration(time.Second)
+		}
+		return d
+	}
+	newPeer := func(me int, seed int64) *Paxos {
+		return loosePeer(t, "seed", 3, me, Config{BackoffSeed: seed})
+	}
+
+	// the same seed and index gives the same delays, but
+	// peers sharing a seed still pick different ones.
+	d0 := delays(newPeer(0, 42))
+	if again := delays(newPeer(0, 42)); !reflect.DeepEqual(d0, again) {
+		t.Fatalf("seed 42 gave %v, then %v", d0, again)
+	}
+	if d1 := delays(newPeer(1, 42)); reflect.DeepEqual(d0, d1) {
+		t.Fatalf("peers 0 and 1 picked the same delays %v", d0)
+	}
+
+	// by default every peer gets its own seed.
+	px0, px1 := newPeer(0, 0), newPeer(1, 0)
+	if px0.BackoffSeed() == px1.BackoffSeed() {
+		t.Fatalf("both peers seeded with %v", px0.BackoffSeed())
+	}
+	if reflect.DeepEqual(delays(px0), delays(px1)) {
+		t.Fatalf("peers 0 and 1 picked the same delays")
+	}
+	// and the seed they report reproduces their delays.
+	px2 := newPeer(2, 0)
+	d2 := delays(px2)
+	if again := delays(newPeer(2, px2.BackoffSeed())); !reflect.DeepEqual(d2, again) {
+		t.Fatalf("BackoffSeed() %v didn't reproduce %v", px2.BackoffSeed(), d2)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestAcceptedValue(t *testing.T) {
+	fmt.Printf("Test: AcceptedValue reports undecided accepts ...\n")
+
+	px := loosePeer(t, "accepted", 3, 0, Config{})
+
+	if _, _, ok := px.AcceptedValue(3); ok {
+		t.Fatalf("AcceptedValue(3) ok before any Accept")
+	}
+
+	// a proposer got this far with peer 0, then was cut off.
+	pnum := "100-1"
+	px.Prepare(&PrepareArgs{Seq: 3, PNum: pnum}, &PrepareReply{})
+	if _, _, ok := px.AcceptedValue(3); ok {
+		t.Fatalf("AcceptedValue(3) ok after just a Prepare")
+	}
+	var reply AcceptReply
+	px.Accept(&AcceptArgs{Seq: 3, PNum: pnum, Value: "x"}, &reply)
+	if reply.Err != OK {
+		t.Fatalf("Accept: %v", reply.Err)
+	}
+
+	n, v, ok := px.AcceptedValue(3)
+	if !ok || n != pnum || v != "x" {
+		t.Fatalf("AcceptedValue(3) = %v, %v, %v", n, v, ok)
+	}
+	if fate, _ := px.Status(3); fate != Pending {
+		t.Fatalf("Status(3) is %v, expected Pending", fate)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestAcceptAfterDecide(t *testing.T) {
+	fmt.Printf("Test: Accept can't change a decided value ...\n")
+
+	px := loosePeer(t, "acceptdecided", 3, 0, Config{})
+
+	px.Decide(&DecideArgs{Seq: 3, Value: "final", PNum: "100-1", Me: 1, Done: -1}, &DecideReply{})
+
+	// a zombie proposer with a higher pnum and another value.
+	var reply AcceptReply
+	px.Accept(&AcceptArgs{Seq: 3, PNum: "900-2", Value: "zombie"}, &reply)
+	if reply.Err != Reject {
+		t.Fatalf("Accept of a different value after Decide: %v", reply.Err)
+	}
+	if fate, v := px.Status(3); fate != Decided || v != "final" {
+		t.Fatalf("decided value changed: %v, %v", fate, v)
+	}
+	if n, v, _ := px.AcceptedValue(3); n != "100-1" || v != "final" {
+		t.Fatalf("accepted state changed: %v, %v", n, v)
+	}
+
+	// one for the decided value is harmless.
+	reply = AcceptReply{}
+	px.Accept(&AcceptArgs{Seq: 3, PNum: "900-2", Value: "final"}, &reply)
+	if reply.Err != OK {
+		t.Fatalf("Accept of the decided value: %v", reply.Err)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestPromisedPNum(t *testing.T) {
+	fmt.Printf("Test: PromisedPNum follows the highest Prepare ...\n")
+
+	px := loosePeer(t, "promised", 3, 0, Config{})
+
+	if _, ok := px.PromisedPNum(3); ok {
+		t.Fatalf("PromisedPNum(3) ok before any Prepare")
+	}
+
+	// two proposers duel; the promise goes to the higher.
+	px.Prepare(&PrepareArgs{Seq: 3, PNum: "100-1"}, &PrepareReply{})
+	px.Prepare(&PrepareArgs{Seq: 3, PNum: "200-2"}, &PrepareReply{})
+	if n, ok := px.PromisedPNum(3); !ok || n != "200-2" {
+		t.Fatalf("PromisedPNum(3) = %v, %v", n, ok)
+	}
+	// a lower one comes late, and is turned away.
+	var reply PrepareReply
+	px.Prepare(&PrepareArgs{Seq: 3, PNum: "150-1"}, &reply)
+	if reply.Err != Reject {
+		t.Fatalf("stale Prepare: %v", reply.Err)
+	}
+	if n, ok := px.PromisedPNum(3); !ok || n != "200-2" {
+		t.Fatalf("PromisedPNum(3) after a stale Prepare = %v, %v", n, ok)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestDecidedEntry(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: DecidedEntry returns the winning pnum with the value ...\n")
+
+	px := loosePeer(t, "entry", 3, 0, Config{})
+
+	// accepted isn't decided.
+	px.Prepare(&PrepareArgs{Seq: 4, PNum: "300-1"}, &PrepareReply{})
+	px.Accept(&AcceptArgs{Seq: 4, PNum: "300-1", Value: "x"}, &AcceptReply{})
+	if v, pnum, ok := px.DecidedEntry(4); ok {
+		t.Fatalf("DecidedEntry(4) = %v, %v before the decision", v, pnum)
+	}
+	px.Decide(&DecideArgs{Seq: 4, PNum: "300-1", Value: "x"}, &DecideReply{})
+	if v, pnum, ok := px.DecidedEntry(4); !ok || v != "x" || pnum != "300-1" {
+		t.Fatalf("DecidedEntry(4) = %v, %v, %v", v, pnum, ok)
+	}
+	px.Kill()
+
+	// a real agreement: every peer reports the pnum of the
+	// proposer that won it.
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("entryagree", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+	pxa[1].Start(0, "y")
+	waitn(t, pxa, 0, npaxos)
+
+	var first string
+	for i := 0; i < npaxos; i++ {
+		v, pnum, ok := pxa[i].DecidedEntry(0)
+		if !ok || v != "y" {
+			t.Fatalf("peer %v: DecidedEntry(0) = %v, %v, %v", i, v, pnum, ok)
+		}
+		if _, who, _ := splitPNum(pnum); who != 1 {
+			t.Fatalf("peer %v: decided by pnum %v, not peer 1's", i, pnum)
+		}
+		if i == 0 {
+			first = pnum
+		} else if pnum != first {
+			t.Fatalf("peer %v: pnum %v, peer 0 says %v", i, pnum, first)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestLearn(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Learn completes an accepted value without imposing one ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("learn", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	// a proposer got "v" accepted by a majority, then died
+	// before sending Decides.
+	for i := 0; i < 2; i++ {
+		pxa[i].Prepare(&PrepareArgs{Seq: 0, PNum: "100-1"}, &PrepareReply{})
+		var reply AcceptReply
+		pxa[i].Accept(&AcceptArgs{Seq: 0, PNum: "100-1", Value: "v"}, &reply)
+		if reply.Err != OK {
+			t.Fatalf("peer %v didn't accept: %v", i, reply.Err)
+		}
+	}
+	if ndecided(t, pxa, 0) != 0 {
+		t.Fatalf("decided before Learn")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	v, err := pxa[2].Learn(ctx, 0)
+	if err != nil || v != "v" {
+		t.Fatalf("Learn(0) = %v, %v", v, err)
+	}
+	waitn(t, pxa, 0, npaxos)
+
+	// nothing accepted: nothing to learn, and nothing decided.
+	if v, err := pxa[2].Learn(ctx, 1); err != ErrNothingAccepted {
+		t.Fatalf("Learn(1) = %v, %v; want ErrNothingAccepted", v, err)
+	}
+	if ndecided(t, pxa, 1) != 0 {
+		t.Fatalf("Learn(1) decided something")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+// a MetricsRegistry that remembers the last value of each
+// sample.
+type mapRegistry map[string]float64
+
+func (r mapRegistry) Counter(name, help string, labels map[string]string, v float64) {
+	r[name+formatLabels(labels)] = v
+}
+
+func (r mapRegistry) Gauge(name, help string, labels map[string]string, v float64) {
+	r[name+formatLabels(labels)] = v
+}
+
+func TestMetrics(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Metrics in the Prometheus text format ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("metrics", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+	for seq := 0; seq < 3; seq++ {
+		pxa[0].Start(seq, seq*10)
+		waitn(t, pxa, seq, npaxos)
+	}
+
+	r := mapRegistry{}
+	pxa[0].CollectMetrics(r)
+	if r["paxos_proposals_total"] != 3 {
+		t.Fatalf("paxos_proposals_total = %v, want 3", r["paxos_proposals_total"])
+	}
+	if r["paxos_decides_total"] != 3 || r[`paxos_instances{state="decided"}`] != 3 || r["paxos_max"] != 2 {
+		t.Fatalf("wrong counts: %v", r)
+	}
+
+	w := httptest.NewRecorder()
+	pxa[0].MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE paxos_proposals_total counter\npaxos_proposals_total 3\n",
+		"# TYPE paxos_rounds_total counter\n",
+		"# TYPE paxos_preemptions_total counter\n",
+		"# TYPE paxos_decides_total counter\n",
+		"paxos_instances{state=\"decided\"} 3\n",
+		"paxos_instances{state=\"pending\"} 0\n",
+		"# TYPE paxos_min gauge\n",
+		"paxos_max 2\n",
+		"# TYPE paxos_last_decided_age_seconds gauge\n",
+		"paxos_rpcs_total{method=\"Paxos.Accept\",peer=\"1\"} ",
+		"paxos_rpc_latency_p99_seconds{method=\"Paxos.Prepare\",peer=\"2\"} ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics lack %q:\n%s", want, body)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestRounds(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Rounds counts a contended agreement's rounds ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("rounds", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	// Propose() can return before its proposer has finished.
+	rounds := func(seq int) int {
+		for iters := 0; iters < 50 && pxa[0].Rounds(seq) == 0; iters++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return pxa[0].Rounds(seq)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := pxa[0].Propose(ctx, 0, "easy"); err != nil {
+		t.Fatalf("Propose: %v", err)
+	}
+	if r := rounds(0); r != 1 {
+		t.Fatalf("uncontended Rounds() = %v", r)
+	}
+	if r := pxa[1].Rounds(0); r != 0 {
+		t.Fatalf("Rounds() = %v on a peer that didn't propose", r)
+	}
+
+	// peer 2 has a majority promise a pnum from a little
+	// way in the future, which peer 0 has to outbid.
+	counter, _, _ := splitPNum(freshPNum(pxa[2]))
+	c, _ := strconv.ParseInt(counter, 10, 64)
+	ahead := fmt.Sprintf("%v-2", c+int64(50*time.Millisecond))
+	for i := 1; i < npaxos; i++ {
+		pxa[i].Prepare(&PrepareArgs{Seq: 1, PNum: ahead}, &PrepareReply{})
+	}
+	if _, err := pxa[0].Propose(ctx, 1, "hard"); err != nil {
+		t.Fatalf("Propose: %v", err)
+	}
+	if r := rounds(1); r <= 1 {
+		t.Fatalf("contended Rounds() = %v", r)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestPriority(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: A starved proposer gets priority ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("priority", i)
+	}
+	// only peer 0 gets priority.
+	for i := 0; i < npaxos; i++ {
+		cfg := Config{MaxRounds: 20}
+		if i == 0 {
+			cfg.PriorityAfter = 3
+			cfg.PriorityBoost = time.Second
+		}
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, cfg)
+	}
+
+	// a rival that keeps every peer promised to a pnum a
+	// little ahead of everyone's clock, so ordinary pnums
+	// lose every round.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			ahead, _ := pxa[2].generatePNumAhead(100 * time.Millisecond)
+			for i := 0; i < npaxos; i++ {
+				for seq := 0; seq < 2; seq++ {
+					pxa[i].Prepare(&PrepareArgs{Seq: seq, PNum: ahead}, &PrepareReply{})
+				}
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// without priority, peer 1 never gets anywhere.
+	if v, err := pxa[1].Propose(ctx, 0, "starved"); err != ErrMaxRounds {
+		t.Fatalf("Propose without priority = %v, %v; want ErrMaxRounds", v, err)
+	}
+
+	// with it, peer 0 wins once it's lost PriorityAfter duels.
+	v, err := pxa[0].Propose(ctx, 1, "boosted")
+	if err != nil || v != "boosted" {
+		t.Fatalf("Propose with priority = %v, %v", v, err)
+	}
+	rounds := 0
+	for iters := 0; iters < 100 && rounds == 0; iters++ {
+		rounds = pxa[0].Rounds(1)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if rounds < 4 || rounds > 6 {
+		t.Fatalf("won after %v rounds; expected just after PriorityAfter", rounds)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestMaxAfterForget(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Max() remembers forgotten instances ...\n")
+
+	var pxa []*Paxos = make([]*Paxos, 1)
+	defer cleanup(pxa)
+	pxa[0] = Make([]string{port("maxforget", 0)}, 0, nil)
+	px := pxa[0]
+
+	if m := px.Max(); m != -1 {
+		t.Fatalf("Max() %v before any instance", m)
+	}
+	for seq := 0; seq < 5; seq++ {
+		px.Start(seq, seq)
+		waitn(t, pxa, seq, 1)
+	}
+	px.Done(4)
+	if m := px.Min(); m != 5 {
+		t.Fatalf("Min() %v after Done(4)", m)
+	}
+	px.mu.Lock()
+	live := len(px.instances)
+	px.mu.Unlock()
+	if live != 0 {
+		t.Fatalf("%v instances left after forgetting them all", live)
+	}
+	if m := px.Max(); m != 4 {
+		t.Fatalf("Max() %v after forgetting everything; expected 4", m)
+	}
+
+	// an instance heard of only through a Prepare counts too.
+	px.Prepare(&PrepareArgs{Seq: 9, PNum: "1-0"}, &PrepareReply{})
+	if m := px.Max(); m != 9 {
+		t.Fatalf("Max() %v after a Prepare for 9", m)
+	}
+	px.ForceForget(20)
+	if m := px.Max(); m != 9 {
+		t.Fatalf("Max() %v after ForceForget(20)", m)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestDryRun(t *testing.T) {
+	fmt.Printf("Test: DryRun logs a round without sending ...\n")
+
+	logs := &lockedBuffer{}
+	px := loosePeer(t, "dryrun", 3, 0, Config{DryRun: true, Logger: log.New(logs, "", 0)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := px.Propose(ctx, 3, "mine"); err != ErrDryRun {
+		t.Fatalf("Propose: %v, expected ErrDryRun", err)
+	}
+	px.mu.Lock()
+	n := len(px.instances)
+	px.mu.Unlock()
+	if n != 0 || px.Max() != -1 {
+		t.Fatalf("dry run left %v instances, Max() %v", n, px.Max())
+	}
+	for _, want := range []string{
+		"dry run: seq 3: pnum ",
+		"would send Prepare(",
+		"nothing accepted here, would choose own value mine",
+		"would send Accept(",
+		"would send Decide(",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Fatalf("log lacks %q:\n%v", want, logs.String())
+		}
+	}
+
+	// with a value already accepted here, that's what it picks.
+	px.Prepare(&PrepareArgs{Seq: 4, PNum: "100-1"}, &PrepareReply{})
+	px.Accept(&AcceptArgs{Seq: 4, PNum: "100-1", Value: "theirs"}, &AcceptReply{})
+	if _, err := px.Propose(ctx, 4, "mine"); err != ErrDryRun {
+		t.Fatalf("Propose: %v, expected ErrDryRun", err)
+	}
+	if !strings.Contains(logs.String(), "accepted here at 100-1, would choose theirs over own value mine") {
+		t.Fatalf("log lacks the value choice:\n%v", logs.String())
+	}
+	if n, v, _ := px.AcceptedValue(4); n != "100-1" || v != "theirs" {
+		t.Fatalf("dry run changed instance 4 to %v, %v", n, v)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestProposers(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Proposers counts wins per peer ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("proposers", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	// peer i proposes i+1 instances on its own.
+	seq := 0
+	for i := 0; i < npaxos; i++ {
+		for j := 0; j <= i; j++ {
+			pxa[i].Start(seq, seq)
+			waitn(t, pxa, seq, npaxos)
+			seq++
+		}
+	}
+	for i := 0; i < npaxos; i++ {
+		wins := pxa[i].Proposers()
+		for who := 0; who < npaxos; who++ {
+			if wins[who] != who+1 {
+				t.Fatalf("peer %v counted %v wins for %v, expected %v: %v",
+					i, wins[who], who, who+1, wins)
+			}
+		}
+	}
+
+	// when two proposers race for one instance, only one wins.
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Start(seq, i)
+	}
+	waitn(t, pxa, seq, npaxos)
+	total := 0
+	for _, n := range pxa[0].Proposers() {
+		total += n
+	}
+	if total != seq+1 {
+		t.Fatalf("%v wins counted for %v instances", total, seq+1)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+// run f, and return what it panicked with, or nil.
+func catchPanic(f func()) (r interface{}) {
+	defer func() {
+		r = recover()
+	}()
+	f()
+	return nil
+}
+
+func TestPNumLimit(t *testing.T) {
+	fmt.Printf("Test: pnums run out at the counter's limit rather than wrap ...\n")
+
+	px := loosePeer(t, "pnumlimit", 1, 0, Config{})
+
+	atomic.StoreInt64(&px.lastPNum, pnumLimit-3)
+	prev := ""
+	for i := 0; i < 2; i++ {
+		pnum, err := px.generatePNum()
+		if err != nil {
+			t.Fatalf("generatePNum below the limit: %v", err)
+		}
+		if prev != "" && comparePNum(pnum, prev) <= 0 {
+			t.Fatalf("%v not above %v", pnum, prev)
+		}
+		prev = pnum
+	}
+	if pnum, err := px.generatePNum(); err != ErrPNumExhausted {
+		t.Fatalf("generatePNum at the limit: %q, %v", pnum, err)
+	}
+	if n := atomic.LoadInt64(&px.lastPNum); n != pnumLimit-1 {
+		t.Fatalf("counter moved to %v", n)
+	}
+	// and a proposal gives up with it, rather than panic.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := px.Propose(ctx, 0, "x"); err != ErrPNumExhausted {
+		t.Fatalf("Propose at the limit: %v", err)
+	}
+
+	// the encoding orders counters of any width.
+	if comparePNum("9223372036854775807-0", "10000000000000000000-0") >= 0 {
+		t.Fatalf("wider counter compared lower")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestStrictSafety(t *testing.T) {
+	fmt.Printf("Test: StrictSafety panics on broken invariants ...\n")
+
+	newPeer := func(strict bool) *Paxos {
+		return loosePeer(t, "strict", 3, 0, Config{StrictSafety: strict})
+	}
+	violation := func(r interface{}, what string) {
+		v, ok := r.(*SafetyViolation)
+		if !ok {
+			t.Fatalf("panicked with %v, expected a SafetyViolation", r)
+		}
+		if v.What != what || !strings.Contains(v.Error(), what) {
+			t.Fatalf("violation %q, expected %q", v.Error(), what)
+		}
+	}
+
+	// a second Decide with another value, as from a buggy
+	// proposer. the handler lets the panic through.
+	px := newPeer(true)
+	px.Decide(&DecideArgs{Seq: 0, PNum: "1-1", Value: "a", Me: 1, Done: -1}, &DecideReply{})
+	r := catchPanic(func() {
+		px.Decide(&DecideArgs{Seq: 0, PNum: "2-2", Value: "b", Me: 2, Done: -1}, &DecideReply{})
+	})
+	violation(r, "decided value changed")
+	// the bad Decide went through before the check caught
+	// it, so the instance now holds "b"; deciding "b" again
+	// changes nothing, and doesn't panic.
+	px.Decide(&DecideArgs{Seq: 0, PNum: "3-2", Value: "b", Me: 2, Done: -1}, &DecideReply{})
+
+	// an accept recorded above the promise, as if by a
+	// bug that skipped the promise check.
+	px = newPeer(true)
+	px.Prepare(&PrepareArgs{Seq: 1, PNum: "5-1"}, &PrepareReply{})
+	px.mu.Lock()
+	px.instances[1].n_a = "9-1"
+	px.instances[1].v_a = "x"
+	px.mu.Unlock()
+	r = catchPanic(func() {
+		px.Accept(&AcceptArgs{Seq: 1, PNum: "6-1", Value: "y"}, &AcceptReply{})
+	})
+	violation(r, "accepted pnum went down")
+
+	// a promise going down can't get past grantable(), so
+	// check the transition itself.
+	before := &instance{state: Pending, n_p: "7-1"}
+	after := &instance{state: Pending, n_p: "6-1"}
+	if what := checkTransition(before, after, reflect.DeepEqual); what != "promise went down" {
+		t.Fatalf("lowered promise: %q", what)
+	}
+	after = &instance{state: Pending, n_p: "7-1", n_a: "8-1"}
+	if what := checkTransition(before, after, reflect.DeepEqual); what != "accepted above its promise" {
+		t.Fatalf("accept above promise: %q", what)
+	}
+
+	// and without StrictSafety, nobody checks.
+	px = newPeer(false)
+	px.Decide(&DecideArgs{Seq: 0, PNum: "1-1", Value: "a", Me: 1, Done: -1}, &DecideReply{})
+	if r := catchPanic(func() {
+		px.Decide(&DecideArgs{Seq: 0, PNum: "2-2", Value: "b", Me: 2, Done: -1}, &DecideReply{})
+	}); r != nil {
+		t.Fatalf("panicked without StrictSafety: %v", r)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestExchangeDone(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Done()s spread with no agreements running ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("exchange", i)
+	}
+	clock := newFakeClock()
+	for i := 0; i < npaxos; i++ {
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, Config{Clock: clock, ExchangeDoneInterval: time.Second})
+	}
+
+	for seq := 0; seq < 5; seq++ {
+		pxa[0].Start(seq, seq)
+		waitn(t, pxa, seq, npaxos)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Done(4)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < npaxos; i++ {
+		if m := pxa[i].Min(); m != 0 {
+			t.Fatalf("peer %v Min() %v before any exchange", i, m)
+		}
+	}
+
+	clock.Advance(time.Second)
+	for i := 0; i < npaxos; i++ {
+		for iters := 0; pxa[i].Min() != 5; iters++ {
+			if iters > 100 {
+				t.Fatalf("peer %v Min() %v after an exchange", i, pxa[i].Min())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestClusterMin(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: ClusterMin asks every peer for its Done ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("clustermin", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+	for seq := 0; seq < 10; seq++ {
+		pxa[0].Start(seq, seq)
+		waitn(t, pxa, seq, npaxos)
+	}
+
+	// nothing is being agreed, so nobody hears of these.
+	pxa[0].Done(7)
+	pxa[1].Done(4)
+	pxa[2].Done(6)
+	if m := pxa[0].Min(); m != 0 {
+		t.Fatalf("local Min() %v; expected a stale 0", m)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < npaxos; i++ {
+		if m, err := pxa[i].ClusterMin(ctx); err != nil || m != 5 {
+			t.Fatalf("peer %v ClusterMin() = %v, %v; expected 5", i, m, err)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: ClusterMin is conservative about unreachable peers ...\n")
+
+	// peer 1 moves on, then can't be reached; nobody heard.
+	pxa[1].Done(8)
+	pxa[1].Kill()
+	if m, err := pxa[0].ClusterMin(ctx); err != nil || m != 0 {
+		t.Fatalf("ClusterMin() = %v, %v with peer 1 unreachable; expected 0", m, err)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestValueEqual(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: custom ValueEqual for slice values ...\n")
+
+	// values are sets of names, in any order.
+	sameSet := func(a, b interface{}) bool {
+		as, aok := a.([]string)
+		bs, bok := b.([]string)
+		if !aok || !bok {
+			return reflect.DeepEqual(a, b)
+		}
+		as = append([]string(nil), as...)
+		bs = append([]string(nil), bs...)
+		sort.Strings(as)
+		sort.Strings(bs)
+		return reflect.DeepEqual(as, bs)
+	}
+	ab := []string{"a", "b"}
+	ba := []string{"b", "a"}
+
+	r1 := PrepareReply{Err: OK, AcceptPnum: "5-1", AcceptValue: ab, HasAccepted: true}
+	r2 := PrepareReply{Err: OK, AcceptPnum: "5-1", AcceptValue: ba, HasAccepted: true}
+	if _, err := selectValue("mine", []PrepareReply{r1, r2}, reflect.DeepEqual); err != ErrConflict {
+		t.Fatalf("DeepEqual saw no conflict: %v", err)
+	}
+	if _, err := selectValue("mine", []PrepareReply{r1, r2}, sameSet); err != nil {
+		t.Fatalf("sameSet saw a conflict: %v", err)
+	}
+
+	// StrictSafety compares with it too.
+	px := loosePeer(t, "valueequal", 3, 0, Config{StrictSafety: true, ValueEqual: sameSet})
+	px.Decide(&DecideArgs{Seq: 0, PNum: "1-1", Value: ab, Me: 1, Done: -1}, &DecideReply{})
+	if r := catchPanic(func() {
+		px.Decide(&DecideArgs{Seq: 0, PNum: "1-1", Value: ba, Me: 1, Done: -1}, &DecideReply{})
+	}); r != nil {
+		t.Fatalf("re-deciding an equal value panicked: %v", r)
+	}
+	px.Kill()
+
+	// and agreement on slice values works end to end.
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("valueequal-c", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, Config{ValueEqual: sameSet})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	seq, decided, err := pxa[1].Append(ctx, ab)
+	if err != nil || !sameSet(decided, ba) {
+		t.Fatalf("Append: %v, %v, %v", seq, decided, err)
+	}
+	// (not waitn(): its == can't compare slices.)
+	for i := 0; i < npaxos; i++ {
+		for iters := 0; ; iters++ {
+			if fate, v := pxa[i].Status(seq); fate == Decided {
+				if !sameSet(v, ab) {
+					t.Fatalf("peer %v decided %v", i, v)
+				}
+				break
+			}
+			if iters > 100 {
+				t.Fatalf("peer %v never decided %v", i, seq)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if ok, err := pxa[2].VerifyConsensus(seq); !ok || err != nil {
+		t.Fatalf("VerifyConsensus: %v, %v", ok, err)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestStatusMinCache(t *testing.T) {
+	fmt.Printf("Test: Status() sees Min() move without calling it ...\n")
+
+	px := loosePeer(t, "mincache", 2, 0, Config{})
+
+	for seq := 0; seq < 5; seq++ {
+		px.Decide(&DecideArgs{Seq: seq, PNum: "1-1", Value: seq, Me: 1, Done: -1}, &DecideReply{})
+	}
+	px.Done(3)
+	px.Decide(&DecideArgs{Seq: 5, PNum: "1-1", Value: 5, Me: 1, Done: 2}, &DecideReply{})
+	for seq := 0; seq < 6; seq++ {
+		fate, _ := px.Status(seq)
+		if seq <= 2 && fate != Forgotten || seq > 2 && fate != Decided {
+			t.Fatalf("Status(%v) %v with both Done()s at 2 or more", seq, fate)
+		}
+	}
+
+	// the cache only goes up, as Min() does.
+	px.Decide(&DecideArgs{Seq: 6, PNum: "1-1", Value: 6, Me: 1, Done: 0}, &DecideReply{})
+	if fate, _ := px.Status(2); fate != Forgotten {
+		t.Fatalf("Status(2) %v after a stale Done", fate)
+	}
+	if m := atomic.LoadInt64(&px.minCache); m != 3 || px.Min() != 3 {
+		t.Fatalf("minCache %v, Min() %v; expected 3", m, px.Min())
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestHideNoOps(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: HideNoOps keeps gap-filling NoOps from the application ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("hidenoops", i)
+	}
+	// peer 2 shows NoOps, to compare.
+	for i := 0; i < npaxos; i++ {
+		cfg := Config{FillGapsAfter: 10 * time.Millisecond, HideNoOps: i != 2}
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, cfg)
+	}
+
+	pxa[0].Start(0, "a")
+	pxa[0].Start(2, "c")
+	waitn(t, pxa, 0, npaxos)
+	waitn(t, pxa, 2, npaxos)
+
+	// nobody's proposing for 1, so the library fills it.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := pxa[0].WaitForPrefix(ctx, 2); err != nil {
+		t.Fatalf("WaitForPrefix: %v", err)
+	}
+	// (waitn() would see the peers' views of 1 differ.)
+	waitn(t, pxa[:2], 1, 2)
+	for iters := 0; ; iters++ {
+		if fate, _ := pxa[2].Status(1); fate == Decided {
+			break
+		}
+		if iters > 100 {
+			t.Fatalf("peer 2 never learned 1")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if fate, v := pxa[0].Status(1); fate != Decided || v != nil {
+		t.Fatalf("hidden Status(1) = %v, %v", fate, v)
+	}
+	if _, v := pxa[2].Status(1); v != (NoOp{}) {
+		t.Fatalf("unhidden Status(1) = %v", v)
+	}
+
+	var got []Entry
+	for e := range pxa[0].Stream(ctx, 0) {
+		got = append(got, e)
+		if len(got) == 2 {
+			break
+		}
+	}
+	if got[0].Seq != 0 || got[0].Value != "a" || got[1].Seq != 2 || got[1].Value != "c" {
+		t.Fatalf("Stream() gave %v; expected 0 and 2", got)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestCompleter(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: a stalled instance is completed after its proposer dies ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("completer", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, Config{CompleteAfter: 100 * time.Millisecond})
+	}
+
+	// peer 2 gets "x" accepted by peers 0 and 1, a majority,
+	// and dies before it can send any Decide.
+	pnum, _ := pxa[2].generatePNum()
+	for i := 0; i < 2; i++ {
+		var prep PrepareReply
+		pxa[i].Prepare(&PrepareArgs{Seq: 0, PNum: pnum}, &prep)
+		var acc AcceptReply
+		pxa[i].Accept(&AcceptArgs{Seq: 0, PNum: pnum, Value: "x"}, &acc)
+		if prep.Err != OK || acc.Err != OK {
+			t.Fatalf("peer %v: Prepare %v, Accept %v", i, prep.Err, acc.Err)
+		}
+	}
+	pxa[2].Kill()
+	if fate, _ := pxa[0].Status(0); fate != Pending {
+		t.Fatalf("Status(0) %v before completing", fate)
+	}
+
+	waitn(t, pxa[:2], 0, 2)
+	if _, v := pxa[0].Status(0); v != "x" {
+		t.Fatalf("completed with %v; expected the accepted x", v)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestAcceptFilter(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: AcceptFilter keeps a value from being decided ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("acceptfilter", i)
+	}
+	noBad := func(seq int, v interface{}) bool {
+		return v != "bad"
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, Config{AcceptFilter: noBad})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := pxa[0].Propose(ctx, 0, "bad"); err != ErrFiltered {
+		t.Fatalf("Propose(bad): %v; expected ErrFiltered", err)
+	}
+	for i := 0; i < npaxos; i++ {
+		if fate, _ := pxa[i].Status(0); fate != Pending {
+			t.Fatalf("peer %v: Status(0) %v after a filtered proposal", i, fate)
+		}
+		if _, _, ok := pxa[i].AcceptedValue(0); ok {
+			t.Fatalf("peer %v accepted the filtered value", i)
+		}
+	}
+
+	// other values still go through, in the same instance.
+	if v, err := pxa[1].Propose(ctx, 0, "good"); err != nil || v != "good" {
+		t.Fatalf("Propose(good): %v, %v", v, err)
+	}
+	waitn(t, pxa, 0, npaxos)
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestRangeProgress(t *testing.T) {
+	fmt.Printf("Test: RangeProgress counts decided seqs in a range ...\n")
+
+	px := loosePeer(t, "rangeprogress", 2, 0, Config{})
+
+	if p := px.RangeProgress(0, 9); p != 0 {
+		t.Fatalf("RangeProgress %v with nothing decided", p)
+	}
+	for seq := 0; seq < 10; seq += 2 {
+		px.Decide(&DecideArgs{Seq: seq, PNum: "1-1", Value: seq, Me: 1, Done: -1}, &DecideReply{})
+	}
+	// an accepted but undecided value doesn't count.
+	px.Prepare(&PrepareArgs{Seq: 1, PNum: "1-1"}, &PrepareReply{})
+	px.Accept(&AcceptArgs{Seq: 1, PNum: "1-1", Value: 1}, &AcceptReply{})
+	if p := px.RangeProgress(0, 9); p != 0.5 {
+		t.Fatalf("RangeProgress %v with half decided", p)
+	}
+
+	// forgetting 0..3 counts 1 and 3 as done too.
+	px.Done(3)
+	px.Decide(&DecideArgs{Seq: 10, PNum: "1-1", Value: 10, Me: 1, Done: 3}, &DecideReply{})
+	if p := px.RangeProgress(0, 9); p != 0.7 {
+		t.Fatalf("RangeProgress %v with 0..3 forgotten", p)
+	}
+	if p := px.RangeProgress(5, 4); p != 1 {
+		t.Fatalf("RangeProgress %v for an empty range", p)
+	}
+	if p := px.RangeProgress(1, 2); p != 1 {
+		t.Fatalf("RangeProgress %v for a forgotten range", p)
+	}
+	// a huge range only costs as much as the live instances.
+	start := time.Now()
+	if p := px.RangeProgress(0, 1<<40); p != 8.0/(1<<40+1) {
+		t.Fatalf("RangeProgress %v for a huge range", p)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("RangeProgress over a huge range took %v", d)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestForceForget(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: ForceForget drops instances despite a dead peer ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("forceforget", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	for seq := 0; seq < 5; seq++ {
+		pxa[0].Start(seq, seq)
+		waitn(t, pxa, seq, npaxos)
+	}
+	// peer 2 dies, so its Done() never moves and Min() is stuck.
+	pxa[2].Kill()
+	pxa[0].Done(4)
+	pxa[1].Done(4)
+	pxa[0].Start(5, 5)
+	waitmajority(t, pxa, 5)
+	if m := pxa[0].Min(); m != 0 {
+		t.Fatalf("Min() is %v with a dead peer", m)
+	}
+
+	pxa[0].ForceForget(3)
+	if m := pxa[0].Min(); m != 3 {
+		t.Fatalf("Min() is %v after ForceForget(3)", m)
+	}
+	pxa[0].mu.Lock()
+	for seq := range pxa[0].instances {
+		if seq < 3 {
+			t.Errorf("instance %v is still there", seq)
+		}
+	}
+	pxa[0].mu.Unlock()
+	for seq := 0; seq < 3; seq++ {
+		if fate, _ := pxa[0].Status(seq); fate != Forgotten {
+			t.Fatalf("Status(%v) is %v, expected Forgotten", seq, fate)
+		}
+	}
+	if fate, v := pxa[0].Status(3); fate != Decided || v != 3 {
+		t.Fatalf("Status(3) is %v, %v", fate, v)
+	}
+	// and a lower ForceForget doesn't bring anything back.
+	pxa[0].ForceForget(1)
+	if m := pxa[0].Min(); m != 3 {
+		t.Fatalf("Min() went back to %v", m)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestMyDone(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Done is monotonic under concurrent callers ...\n")
+
+	var pxa []*Paxos = make([]*Paxos, 1)
+	defer cleanup(pxa)
+	pxa[0] = Make([]string{port("mydone", 0)}, 0, nil)
+	px := pxa[0]
+
+	if d := px.MyDone(); d != -1 {
+		t.Fatalf("MyDone() %v before any Done; expected -1", d)
+	}
+
+	// some callers go up, some go down; watchers check
+	// that MyDone() never goes backwards.
+	const n = 1000
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if g%2 == 0 {
+					px.Done(i)
+				} else {
+					px.Done(n - 1 - i)
+				}
+			}
+		}(g)
+	}
+	stop := make(chan bool)
+	watched := make(chan bool)
+	go func() {
+		last := -1
+		for {
+			select {
+			case <-stop:
+				watched <- true
+				return
+			default:
+			}
+			d := px.MyDone()
+			if d < last {
+				t.Errorf("MyDone() went from %v to %v", last, d)
+			}
+			last = d
+		}
+	}()
+	wg.Wait()
+	stop <- true
+	<-watched
+
+	if d := px.MyDone(); d != n-1 {
+		t.Fatalf("MyDone() %v; expected %v", d, n-1)
+	}
+	px.Done(3)
+	if d := px.MyDone(); d != n-1 {
+		t.Fatalf("MyDone() %v after a lower Done; expected %v", d, n-1)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestStartDecided(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Start on a decided instance sends nothing ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("startdecided", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	pxa[0].Start(0, "first")
+	waitn(t, pxa, 0, npaxos)
+	// let the Decides finish going round.
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < npaxos; i++ {
+		atomic.StoreInt32(&pxa[i].rpcCount, 0)
+	}
+
+	pxa[0].Start(0, "second")
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < npaxos; i++ {
+		if n := atomic.LoadInt32(&pxa[i].rpcCount); n != 0 {
+			t.Fatalf("peer %v got %v RPCs for a decided instance", i, n)
+		}
+		if _, v := pxa[i].Status(0); v != "first" {
+			t.Fatalf("peer %v has seq 0 as %v", i, v)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestCanMakeProgress(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: CanMakeProgress needs a majority ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("progress", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !pxa[0].CanMakeProgress(ctx) {
+		t.Fatalf("CanMakeProgress false with every peer up")
+	}
+
+	pxa[1].Kill()
+	pxa[2].Kill()
+	if pxa[0].CanMakeProgress(ctx) {
+		t.Fatalf("CanMakeProgress true with a majority dead")
+	}
+
+	pxa[1] = Make(pxh, 1, nil)
+	pxa[2] = Make(pxh, 2, nil)
+	if !pxa[0].CanMakeProgress(ctx) {
+		t.Fatalf("CanMakeProgress false after the peers came back")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestCancelProposal(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: CancelProposal stops a stuck proposer ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("cancel", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+	pxa[1].Kill()
+	pxa[2].Kill()
+
+	// start() registers the proposer before it returns.
+	errs := make(chan error, 1)
+	if err := pxa[0].start(0, "", "stuck", errs, nil); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	pxa[1].CancelProposal(0) // another peer's cancel is no business of 0's
+	pxa[0].CancelProposal(1) // nor is another seq's
+	pxa[0].mu.Lock()
+	n := len(pxa[0].proposals[0])
+	pxa[0].mu.Unlock()
+	if n != 1 {
+		t.Fatalf("%v proposers registered for 0 after other cancels", n)
+	}
+	select {
+	case err := <-errs:
+		t.Fatalf("proposer returned %v without a majority", err)
+	default:
+	}
+
+	pxa[0].CancelProposal(0)
+	select {
+	case err := <-errs:
+		if err != ErrCancelled {
+			t.Fatalf("cancelled proposer returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("proposer didn't stop")
+	}
+	pxa[0].mu.Lock()
+	n = len(pxa[0].proposals)
+	pxa[0].mu.Unlock()
+	if n != 0 {
+		t.Fatalf("%v proposals still registered", n)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestMaxValueBytes(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Oversized values are refused before sending ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("maxvalue", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, Config{MaxValueBytes: 1000})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := pxa[0].Propose(ctx, 0, strings.Repeat("x", 2000))
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("oversized Propose: %v, expected ErrValueTooLarge", err)
+	}
+	pxa[0].Start(0, strings.Repeat("x", 2000))
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < npaxos; i++ {
+		if n := atomic.LoadInt32(&pxa[i].rpcCount); n != 0 {
+			t.Fatalf("peer %v got %v RPCs for an oversized value", i, n)
+		}
+		if fate, known, _ := pxa[i].StatusEx(0); fate != Pending || known {
+			t.Fatalf("peer %v has seq 0 %v, known %v", i, fate, known)
+		}
+	}
+
+	v, err := pxa[0].Propose(ctx, 0, "small")
+	if err != nil || v != "small" {
+		t.Fatalf("Propose of a small value: %v %v", v, err)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestStartIfPending(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: StartIfPending skips instances decided elsewhere ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("ifpending", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	// only peer 1 has heard that seq 0 was decided.
+	pxa[1].Decide(&DecideArgs{Seq: 0, Value: "remote", PNum: "1-1", Me: 1, Done: -1}, &DecideReply{})
+
+	if pxa[0].StartIfPending(0, "local") {
+		t.Fatalf("StartIfPending proposed for a decided instance")
+	}
+	if fate, v := pxa[0].Status(0); fate != Decided || v != "remote" {
+		t.Fatalf("StartIfPending didn't learn the decision: %v %v", fate, v)
+	}
+	if fate, _ := pxa[2].Status(0); fate != Pending {
+		t.Fatalf("StartIfPending ran a round: peer 2 has seq 0 %v", fate)
+	}
+
+	// a genuinely pending instance is proposed.
+	if !pxa[0].StartIfPending(1, "fresh") {
+		t.Fatalf("StartIfPending declined a pending instance")
+	}
+	waitn(t, pxa, 1, npaxos)
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestMinMonotonic(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Min doesn't go back when a peer's Done does ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("minmono", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	for seq := 0; seq < 5; seq++ {
+		pxa[0].Start(seq, seq)
+		waitn(t, pxa, seq, npaxos)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Done(3)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Start(5+i, i)
+		waitn(t, pxa, 5+i, npaxos)
+	}
+	if m := pxa[0].Min(); m != 4 {
+		t.Fatalf("Min() %v; expected 4", m)
+	}
+
+	// peer 2 comes back with no Done, as if new or reset.
+	pxa[0].mu.Lock()
+	pxa[0].dones[2] = -1
+	pxa[0].mu.Unlock()
+	if m := pxa[0].Min(); m != 4 {
+		t.Fatalf("Min() went back to %v", m)
+	}
+	if fate, _ := pxa[0].Status(2); fate != Forgotten {
+		t.Fatalf("seq 2 un-forgotten: %v", fate)
+	}
+
+	// and moves on once everyone passes it.
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Done(6)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Start(8+i, i)
+		waitn(t, pxa, 8+i, npaxos)
+	}
+	if m := pxa[0].Min(); m != 7 {
+		t.Fatalf("Min() %v; expected 7", m)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestStatusRange(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: StatusRange matches Status ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("statusrange", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	for seq := 0; seq < 10; seq += 2 {
+		pxa[0].Start(seq, seq*100)
+		waitn(t, pxa, seq, npaxos)
+	}
+	pxa[1].Prepare(&PrepareArgs{Seq: 5, PNum: freshPNum(pxa[1])}, &PrepareReply{})
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Done(1)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Start(20+i, i)
+		waitn(t, pxa, 20+i, npaxos)
+	}
+
+	var reply StatusRangeReply
+	if !call(pxh[1], "Paxos.StatusRange", &StatusRangeArgs{From: 0, To: 12, Values: true}, &reply) {
+		t.Fatalf("StatusRange RPC failed")
+	}
+	if reply.To != 12 || len(reply.Fates) != 13 {
+		t.Fatalf("StatusRange covered to %v with %v fates", reply.To, len(reply.Fates))
+	}
+	for seq := 0; seq <= 12; seq++ {
+		fate, v := pxa[1].Status(seq)
+		if reply.Fates[seq] != fate {
+			t.Fatalf("seq %v: StatusRange says %v, Status %v", seq, reply.Fates[seq], fate)
+		}
+		if fate == Decided && reply.Values[seq] != v {
+			t.Fatalf("seq %v: StatusRange value %v, Status %v", seq, reply.Values[seq], v)
+		}
+	}
+	if reply.Fates[0] != Forgotten || reply.Fates[2] != Decided || reply.Fates[5] != Pending {
+		t.Fatalf("StatusRange fates %v", reply.Fates)
+	}
+
+	// big ranges are capped.
+	reply = StatusRangeReply{}
+	if !call(pxh[1], "Paxos.StatusRange", &StatusRangeArgs{From: 0, To: 5000}, &reply) {
+		t.Fatalf("StatusRange RPC failed")
+	}
+	if reply.To != maxStatusRange-1 || len(reply.Fates) != maxStatusRange || reply.Values != nil {
+		t.Fatalf("capped StatusRange: To %v, %v fates, values %v", reply.To, len(reply.Fates), reply.Values)
+	}
+
+	// extreme ranges neither overflow past the cap nor wrap.
+	ranges := []StatusRangeArgs{
+		{From: math.MinInt, To: math.MaxInt},
+		{From: math.MaxInt - 2, To: math.MaxInt},
+		{From: 5, To: 4},
+	}
+	for _, args := range ranges {
+		reply = StatusRangeReply{}
+		pxa[1].StatusRange(&args, &reply)
+		if len(reply.Fates) > maxStatusRange {
+			t.Fatalf("StatusRange(%v, %v) covered %v seqs", args.From, args.To, len(reply.Fates))
+		}
+	}
+	if len(reply.Fates) != 0 {
+		t.Fatalf("empty StatusRange covered %v seqs", len(reply.Fates))
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestPreVote(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: A flapping peer doesn't preempt a working proposer ...\n")
+
+	const npaxos = 5
+	const flapper = 4
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("prevote", i)
+	}
+
+	// the flapper's outgoing RPCs go nowhere while it's cut off.
+	var cutoff int32
+	resolver := func(i int) string {
+		if atomic.LoadInt32(&cutoff) != 0 {
+			return pxh[i] + "-unreachable"
+		}
+		return pxh[i]
+	}
+	// count the Prepares that reach the others from the flapper.
+	var disruptions int32
+	intercept := func(method string, args interface{}) bool {
+		if method == "Paxos.Prepare" {
+			if _, proposer, _ := splitPNum(args.(*PrepareArgs).PNum); proposer == flapper {
+				atomic.AddInt32(&disruptions, 1)
+			}
+		}
+		return true
+	}
+	for i := 0; i < npaxos; i++ {
+		cfg := Config{PreVoteWindow: time.Second, Intercept: intercept}
+		if i == flapper {
+			cfg.Resolver = resolver
+		}
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, cfg)
+	}
+
+	for seq := 0; seq < 5; seq++ {
+		// the flapper wants seq while cut off...
+		atomic.StoreInt32(&cutoff, 1)
+		pxa[flapper].Start(seq, "flap")
+
+		// ...while peer 0 has a majority's promise for it...
+		pnum, _ := pxa[0].generatePNum()
+		for i := 0; i < npaxos-1; i++ {
+			var reply PrepareReply
+			pxa[i].Prepare(&PrepareArgs{Seq: seq, PNum: pnum}, &reply)
+		}
+
+		// ...and the flapper comes back before peer 0 finishes.
+		atomic.StoreInt32(&cutoff, 0)
+		time.Sleep(50 * time.Millisecond)
+
+		pxa[0].StartWithPNum(seq, pnum, "lead")
+		waitn(t, pxa, seq, npaxos)
+		for i := 0; i < npaxos; i++ {
+			if _, v := pxa[i].Status(seq); v != "lead" {
+				t.Fatalf("seq %v: peer %v decided %v", seq, i, v)
+			}
+		}
+	}
+	if n := atomic.LoadInt32(&disruptions); n != 0 {
+		t.Fatalf("flapper sent %v Prepares during others' rounds", n)
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: PreVote lets a stalled instance be taken over ...\n")
+
+	// peer 0 promises seq 10 and then goes quiet.
+	pnum, _ := pxa[0].generatePNum()
+	for i := 0; i < npaxos; i++ {
+		var reply PrepareReply
+		pxa[i].Prepare(&PrepareArgs{Seq: 10, PNum: pnum}, &reply)
+	}
+	start := time.Now()
+	pxa[2].Start(10, "takeover")
+	waitn(t, pxa, 10, npaxos)
+	if d := time.Since(start); d < 900*time.Millisecond {
+		t.Fatalf("took over a fresh promise after %v", d)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+// a Tracer that records finished spans as "parent/name seq pnum",
+// and the responders it's told of as "parent/name seq [peers]".
+type recordingTracer struct {
+	mu         sync.Mutex
+	spans      []string
+	responders []string
+}
+
+type recordedSpan struct {
+	t    *recordingTracer
+	name string
+	seq  int
+	pnum string
+}
+
+func (rt *recordingTracer) StartRound(seq int, pnum string) Span {
+	return &recordedSpan{rt, "round", seq, pnum}
+}
+
+func (s *recordedSpan) Child(name string) Span {
+	return &recordedSpan{s.t, s.name + "/" + name, s.seq, s.pnum}
+}
+
+func (s *recordedSpan) Responders(peers []int) {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.t.responders = append(s.t.responders, fmt.Sprintf("%v %v %v", s.name, s.seq, peers))
+}
+
+func (s *recordedSpan) End() {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.t.spans = append(s.t.spans, fmt.Sprintf("%v %v %v", s.name, s.seq, s.pnum))
+}
+
+func TestTracer(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Tracer sees a span per round and phase ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("tracer", i)
+	}
+	rt := &recordingTracer{}
+	for i := 0; i < npaxos; i++ {
+		cfg := Config{}
+		if i == 0 {
+			cfg.Tracer = rt
+		}
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, cfg)
+	}
+
+	pnum, _ := pxa[0].generatePNum()
+	pxa[0].StartWithPNum(7, pnum, "traced")
+	waitn(t, pxa, 7, npaxos)
+
+	rt.mu.Lock()
+	got := strings.Join(rt.spans, "\n")
+	rt.mu.Unlock()
+	expected := strings.Join([]string{
+		"round/Prepare 7 " + pnum,
+		"round/Accept 7 " + pnum,
+		"round/Decide 7 " + pnum,
+		"round 7 " + pnum,
+	}, "\n")
+	if got != expected {
+		t.Fatalf("spans:\n%v\nexpected:\n%v", got, expected)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+// a Transport that can't reach some peers, though they may
+// be able to reach it.
+type cutTransport struct {
+	Transport
+	cut map[int]bool
+}
+
+func (t *cutTransport) Call(peer int, method string, args interface{}, reply interface{}) bool {
+	if t.cut[peer] {
+		return false
+	}
+	return t.Transport.Call(peer, method, args, reply)
+}
+
+func TestResponders(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Tracer sees which peers responded through a one-way partition ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = "responders-" + strconv.Itoa(i)
+	}
+	mem := &MemNetwork{}
+	tracers := make([]*recordingTracer, npaxos)
+	for i := 0; i < npaxos; i++ {
+		tracers[i] = &recordingTracer{}
+		cfg := Config{Tracer: tracers[i], Transport: mem.Transport}
+		if i == 0 {
+			// 0 can't reach 2, but 2 can reach 0.
+			cfg.Transport = func(px *Paxos) Transport {
+				return &cutTransport{mem.Transport(px), map[int]bool{2: true}}
+			}
+		}
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, cfg)
+	}
+
+	pxa[0].Start(0, "from 0")
+	waitn(t, pxa[:2], 0, 2)
+	pxa[2].Start(1, "from 2")
+	waitn(t, pxa, 1, npaxos)
+
+	check := func(rt *recordingTracer, expected []string) {
+		rt.mu.Lock()
+		got := strings.Join(rt.responders, "\n")
+		rt.mu.Unlock()
+		if got != strings.Join(expected, "\n") {
+			t.Fatalf("responders:\n%v\nexpected:\n%v", got, strings.Join(expected, "\n"))
+		}
+	}
+	check(tracers[0], []string{"round/Prepare 0 [0 1]", "round/Accept 0 [0 1]"})
+	check(tracers[2], []string{"round/Prepare 1 [0 1 2]", "round/Accept 1 [0 1 2]"})
+
+	fmt.Printf("  ... Passed\n")
+}
+
+// an AuditSink that keeps what it's told.
+type recordingAudit struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (ra *recordingAudit) AuditLog(e AuditEvent) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	ra.events = append(ra.events, e)
+}
+
+func TestAudit(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Audit sees the promise, accept and decision ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("audit", i)
+	}
+	audits := make([]*recordingAudit, npaxos)
+	for i := 0; i < npaxos; i++ {
+		audits[i] = &recordingAudit{}
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, Config{Audit: audits[i]})
+	}
+
+	pnum, _ := pxa[0].generatePNum()
+	pxa[0].StartWithPNum(0, pnum, "audited")
+	waitn(t, pxa, 0, npaxos)
+
+	hash := hashValue("audited")
+	expected := []AuditEvent{
+		{Kind: AuditPrepareGranted, Seq: 0, PNum: pnum},
+		{Kind: AuditAcceptGranted, Seq: 0, PNum: pnum, ValueHash: hash},
+		{Kind: AuditDecided, Seq: 0, PNum: pnum, ValueHash: hash},
+	}
+	for i := 0; i < npaxos; i++ {
+		audits[i].mu.Lock()
+		got := append([]AuditEvent(nil), audits[i].events...)
+		audits[i].mu.Unlock()
+		if len(got) != len(expected) {
+			t.Fatalf("peer %v audited %v events: %+v", i, len(got), got)
+		}
+		for j := range got {
+			if got[j].Time.IsZero() {
+				t.Fatalf("peer %v event %v has no time", i, j)
+			}
+			got[j].Time = time.Time{}
+			if got[j] != expected[j] {
+				t.Fatalf("peer %v event %v: %+v, expected %+v", i, j, got[j], expected[j])
+			}
+		}
+	}
+	if hashValue("audited") == hashValue("other") {
+		t.Fatalf("different values hash the same")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestSelfPreemption(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: A proposer's later rounds supersede its earlier ones ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("selfpreempt", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	prepare := func(pnum string) []PrepareReply {
+		replies := make([]PrepareReply, npaxos)
+		for i := 0; i < npaxos; i++ {
+			pxa[i].Prepare(&PrepareArgs{Seq: 0, PNum: pnum}, &replies[i])
+		}
+		return replies
+	}
+	accept := func(pnum string, v interface{}) int {
+		n := 0
+		for i := 0; i < npaxos; i++ {
+			var reply AcceptReply
+			pxa[i].Accept(&AcceptArgs{Seq: 0, PNum: pnum, Value: v}, &reply)
+			if reply.Err == OK {
+				n++
+			}
+		}
+		return n
+	}
+
+	// round one gets "first" accepted, but isn't decided.
+	p1 := "100-1"
+	prepare(p1)
+	if n := accept(p1, "first"); n != npaxos {
+		t.Fatalf("round one accepted by %v", n)
+	}
+
+	// round two, same proposer, higher pnum: promised, and
+	// told about round one's accept.
+	p2 := "200-1"
+	for _, reply := range prepare(p2) {
+		if reply.Err != OK || reply.AcceptPnum != p1 || reply.AcceptValue != "first" {
+			t.Fatalf("round two prepare: %+v", reply)
+		}
+	}
+	// re-sending round two's Prepare, as after lost replies,
+	// is still OK.
+	for _, reply := range prepare(p2) {
+		if reply.Err != OK {
+			t.Fatalf("repeated round two prepare rejected")
+		}
+	}
+	if n := accept(p2, "first"); n != npaxos {
+		t.Fatalf("round two accepted by %v", n)
+	}
+
+	// round one's messages are now stale.
+	if n := accept(p1, "stale"); n != 0 {
+		t.Fatalf("stale round one accept granted by %v", n)
+	}
+	for _, reply := range prepare(p1) {
+		if reply.Err != Reject || reply.AcceptPnum != p2 {
+			t.Fatalf("stale round one prepare: %+v", reply)
+		}
+	}
+
+	// a full round keeps the accepted value.
+	pxa[1].Start(0, "second")
+	waitn(t, pxa, 0, npaxos)
+	if _, v := pxa[0].Status(0); v != "first" {
+		t.Fatalf("decided %v; expected first", v)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestStream(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: Stream delivers in order while agreement goes on ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("stream", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i] = Make(pxh, i, nil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	ch := pxa[2].Stream(ctx, 0)
+
+	// decide in blocks, each block back to front.
+	const n = 60
+	go func() {
+		for block := 0; block < n; block += 10 {
+			for seq := block + 9; seq >= block; seq-- {
+				pxa[seq%npaxos].Start(seq, seq*10)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	// a slow consumer.
+	for seq := 0; seq < n; seq++ {
+		e, ok := <-ch
+		if !ok {
+			t.Fatalf("stream closed at %v", seq)
+		}
+		if e.Err != nil || e.Seq != seq || e.Value != seq*10 {
+			t.Fatalf("expected seq %v, got %+v", seq, e)
+		}
+		if len(ch) > streamBuffer {
+			t.Fatalf("stream buffered %v entries", len(ch))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	fmt.Printf("  ... Passed\n")
+
+	fmt.Printf("Test: Stream from below Min fails ...\n")
+
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Done(20)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i].Start(n+i, i)
+		waitn(t, pxa, n+i, npaxos)
+	}
+	e, ok := <-pxa[2].Stream(ctx, 5)
+	if !ok || e.Err != ErrForgotten || e.Seq != 5 {
+		t.Fatalf("stream from a forgotten seq: %+v, %v", e, ok)
+	}
+
+	// cancelling closes the stream.
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	ch = pxa[2].Stream(ctx2, 1000)
+	cancel2()
+	select {
+	case e, ok := <-ch:
+		if ok {
+			t.Fatalf("cancelled stream sent %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("cancelled stream not closed")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestSendAcceptDecidedElsewhere(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: sendAccept stops once the instance is decided ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("acceptdecided", i)
+	}
+
+	// when peer 1 gets peer 0's Accept, peer 0 hears that
+	// another proposer has already got seq 0 decided.
+	var once sync.Once
+	decideOn0 := func(method string, args interface{}) bool {
+		if method == "Paxos.Accept" {
+			once.Do(func() {
+				pxa[0].Decide(&DecideArgs{Seq: 0, Value: "other", PNum: "1-2", Me: 2, Done: -1}, &DecideReply{})
+			})
+		}
+		return true
+	}
+	var accepts2 int32
+	count := func(method string, args interface{}) bool {
+		if method == "Paxos.Accept" {
+			atomic.AddInt32(&accepts2, 1)
+		}
+		return true
+	}
+	cfgs := []Config{{}, {Intercept: decideOn0}, {Intercept: count}}
+	for i := 0; i < npaxos; i++ {
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, cfgs[i])
+	}
+
+	pnum, _ := pxa[0].generatePNum()
+	if ok, _, decided, _ := pxa[0].sendAccept(0, pnum, "mine", noopSpan{}); ok || !decided {
+		t.Fatalf("sendAccept returned ok %v, decided %v", ok, decided)
+	}
+	if n := atomic.LoadInt32(&accepts2); n != 0 {
+		t.Fatalf("peer 2 got %v Accepts after seq 0 was decided", n)
+	}
+
+	// and propose stops there too.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	v, err := pxa[0].Propose(ctx, 0, "mine")
+	if err != nil || v != "other" {
+		t.Fatalf("Propose: %v %v", v, err)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestStaleStatus(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	fmt.Printf("Test: StaleStatus only trusts recent decisions ...\n")
+
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	fc := newFakeClock()
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port("stalestatus", i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, Config{Clock: fc})
+	}
+
+	if _, _, ok := pxa[1].StaleStatus(0, time.Hour); ok {
+		t.Fatalf("StaleStatus ok for an undecided instance")
+	}
+
+	pxa[0].Start(0, "fresh")
+	waitn(t, pxa, 0, npaxos)
+
+	fate, v, ok := pxa[1].StaleStatus(0, time.Second)
+	if fate != Decided || v != "fresh" || !ok {
+		t.Fatalf("fresh StaleStatus: %v %v %v", fate, v, ok)
+	}
+
+	fc.Advance(2 * time.Second)
+	fate, v, ok = pxa[1].StaleStatus(0, time.Second)
+	if fate != Decided || v != "fresh" || ok {
+		t.Fatalf("old StaleStatus: %v %v %v", fate, v, ok)
+	}
+	if _, _, ok := pxa[1].StaleStatus(0, time.Minute); !ok {
+		t.Fatalf("StaleStatus not ok within a longer maxAge")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func benchmarkAgreement(b *testing.B, tag string, cfg Config) {
+	const npaxos = 3
+	var pxa []*Paxos = make([]*Paxos, npaxos)
+	var pxh []string = make([]string, npaxos)
+	defer cleanup(pxa)
+
+	for i := 0; i < npaxos; i++ {
+		pxh[i] = port(tag, i)
+	}
+	for i := 0; i < npaxos; i++ {
+		pxa[i], _ = MakeWithConfig(pxh, i, nil, cfg)
+	}
+
+	b.ResetTimer()
+	for seq := 0; seq < b.N; seq++ {
+		pxa[0].Start(seq, seq)
+		for {
+			if fate, _ := pxa[0].Status(seq); fate == Decided {
+				break
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+	b.StopTimer()
+
+	conns := 0
+	for i := 0; i < npaxos; i++ {
+		conns += int(atomic.LoadInt32(&pxa[i].rpcCount))
+	}
+	b.ReportMetric(float64(conns)/float64(b.N), "conns/op")
+}
+
+func BenchmarkAgreementDial(b *testing.B) {
+	benchmarkAgreement(b, "bdial", Config{})
+}
+
+func BenchmarkAgreementReuseConns(b *testing.B) {
+	benchmarkAgreement(b, "breuse", Config{ReuseConns: true})
+}
+
+// a peer, with no listener, that knows n decided instances.
+func statusPeer(b *testing.B, n int) *Paxos {
+	px := loosePeer(b, "bstatus", 3, 0, Config{})
+	px.mu.Lock()
+	for seq := 0; seq < n; seq++ {
+		px.decideLocked(seq, "1-1", seq)
+	}
+	px.mu.Unlock()
+	return px
+}
+
+func BenchmarkStatus(b *testing.B) {
+	px := statusPeer(b, 1000)
+	defer px.Kill()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		px.Status(i % 1000)
+	}
+}
+
+// what Status() used to cost: a full Min(), which scans
+// every instance, on each call.
+func BenchmarkStatusWithMin(b *testing.B) {
+	px := statusPeer(b, 1000)
+	defer px.Kill()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		px.Min()
+		px.Status(i % 1000)
+	}
+}
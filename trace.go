@@ -0,0 +1,54 @@
+package paxos
+
+//
+// tracing hooks for propose rounds. each round is a span
+// tagged with its seq and pnum, with a child span for each
+// of its Prepare, Accept and Decide phases, so a tracer can
+// show where a slow agreement spent its time.
+//
+
+//
+// a span started by a Tracer. Child starts a span nested
+// in it; End finishes it.
+//
+type Span interface {
+	Child(name string) Span
+	End()
+}
+
+type Tracer interface {
+	// a propose round for seq with pnum is starting.
+	StartRound(seq int, pnum string) Span
+}
+
+//
+// a Span that also wants to know which peers said OK in
+// its phase, which shows up asymmetric partitions that a
+// count of OKs hides. a round's Prepare and Accept spans
+// get the indices of the peers that granted, in order,
+// just before they End.
+//
+type ResponderSpan interface {
+	Span
+	Responders(peers []int)
+}
+
+// tell span, if it wants to know, which peers said OK.
+func recordResponders(span Span, peers []int) {
+	if rs, ok := span.(ResponderSpan); ok {
+		rs.Responders(peers)
+	}
+}
+
+// what rounds get when Config.Tracer is nil.
+type noopSpan struct{}
+
+func (noopSpan) Child(name string) Span { return noopSpan{} }
+func (noopSpan) End()                   {}
+
+func (px *Paxos) startRound(seq int, pnum string) Span {
+	if px.config.Tracer == nil {
+		return noopSpan{}
+	}
+	return px.config.Tracer.StartRound(seq, pnum)
+}
@@ -0,0 +1,111 @@
+package paxos
+
+//
+// how peers reach each other. a Transport delivers this
+// peer's calls to the others, and the others' calls to
+// this peer's Handle(). the default is net/rpc over unix
+// sockets; Config.Transport swaps in another, e.g. one
+// over gRPC or HTTP, or in-process, as MemNetwork does.
+//
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"time"
+)
+
+type Transport interface {
+	// start accepting calls at addr, this peer's own
+	// entry in the peers passed to Make().
+	Listen(addr string) error
+
+	// hand incoming calls to Handle() until Close().
+	// called in its own goroutine, after Listen().
+	Serve()
+
+	// send a call for method ("Paxos.Prepare" &c) to peer
+	// index peer, filling in reply. true if it replied,
+	// like call().
+	Call(peer int, method string, args interface{}, reply interface{}) bool
+
+	// stop serving, soon after the peer is killed.
+	Close() error
+}
+
+//
+// run the handler for method on args, as if it had
+// arrived from another peer. this is what a Transport
+// calls to deliver a call.
+//
+func (px *Paxos) Handle(method string, args interface{}, reply interface{}) error {
+	switch method {
+	case "Paxos.Prepare":
+		return px.Prepare(args.(*PrepareArgs), reply.(*PrepareReply))
+	case "Paxos.Accept":
+		return px.Accept(args.(*AcceptArgs), reply.(*AcceptReply))
+	case "Paxos.Decide":
+		return px.Decide(args.(*DecideArgs), reply.(*DecideReply))
+	case "Paxos.Query":
+		return px.Query(args.(*QueryArgs), reply.(*QueryReply))
+	case "Paxos.Lease":
+		return px.Lease(args.(*LeaseArgs), reply.(*LeaseReply))
+	case "Paxos.Fetch":
+		return px.Fetch(args.(*FetchArgs), reply.(*FetchReply))
+	case "Paxos.PreVote":
+		return px.PreVote(args.(*PreVoteArgs), reply.(*PreVoteReply))
+	case "Paxos.StatusRange":
+		return px.StatusRange(args.(*StatusRangeArgs), reply.(*StatusRangeReply))
+	case "Paxos.ExchangeDone":
+		return px.ExchangeDone(args.(*ExchangeDoneArgs), reply.(*ExchangeDoneReply))
+	}
+	return fmt.Errorf("paxos: no method %v", method)
+}
+
+// how often closeWhenDead() looks to see if it's been killed.
+const deadPoll = 10 * time.Millisecond
+
+// close px.transport once the peer is killed. Kill() only
+// closes the listener, as it always has; this takes care of
+// the rest, such as the connections still being served and
+// the pooled ones, or a MemTransport's place on its network.
+func (px *Paxos) closeWhenDead() {
+	for !px.isdead() {
+		time.Sleep(deadPoll)
+	}
+	px.transport.Close()
+}
+
+// the default Transport: net/rpc, over unix sockets.
+type rpcTransport struct {
+	px   *Paxos
+	rpcs *rpc.Server
+}
+
+func (t *rpcTransport) Listen(addr string) error {
+	// change "unix" to "tcp" to use over a network.
+	os.Remove(addr) // only needed for "unix"
+	l, e := net.Listen("unix", addr)
+	if e != nil {
+		return fmt.Errorf("listen error: %v", e)
+	}
+	t.px.l = l
+	return nil
+}
+
+func (t *rpcTransport) Serve() {
+	t.px.serve(t.rpcs)
+}
+
+func (t *rpcTransport) Call(peer int, method string, args interface{}, reply interface{}) bool {
+	return t.px.call(t.px.resolve(peer), method, args, reply)
+}
+
+func (t *rpcTransport) Close() error {
+	if t.px.l != nil {
+		t.px.l.Close()
+	}
+	t.px.closeConns()
+	return nil
+}
@@ -0,0 +1,100 @@
+package paxos
+
+import "time"
+
+const (
+	defaultUniqueTTL  = time.Minute
+	defaultUniqueKeys = 1024
+)
+
+type uniqueEntry struct {
+	seq int
+	at  time.Time
+}
+
+//
+// start agreement on v at the next free instance, unless
+// StartUnique() was recently called with the same key, in
+// which case return that call's seq without proposing again.
+// this lets an application retry after a timeout without
+// ending up with v in the log twice. keys are remembered for
+// Config.UniqueTTL, up to Config.UniqueKeys of them.
+//
+// like Start(), this doesn't wait for agreement, and if
+// another peer proposes a different value for the same seq
+// that value may win.
+//
+func (px *Paxos) StartUnique(key string, v interface{}) (int, error) {
+	px.mu.Lock()
+	px.expireUniquesLocked()
+	if e, ok := px.uniques[key]; ok {
+		px.mu.Unlock()
+		return e.seq, nil
+	}
+
+	seq := px.minLocked()
+	for s := range px.instances {
+		if s >= seq {
+			seq = s + 1
+		}
+	}
+	for s := range px.spilled {
+		if s >= seq {
+			seq = s + 1
+		}
+	}
+	for _, e := range px.uniques {
+		if e.seq >= seq {
+			seq = e.seq + 1
+		}
+	}
+	px.uniques[key] = uniqueEntry{seq, px.clock.Now()}
+	px.uniqueKeys = append(px.uniqueKeys, key)
+	px.expireUniquesLocked()
+	px.mu.Unlock()
+
+	if err := px.start(seq, "", v, nil, nil); err != nil {
+		px.mu.Lock()
+		px.forgetUniqueLocked(key)
+		px.mu.Unlock()
+		return -1, err
+	}
+	return seq, nil
+}
+
+// drop key from px.uniques and from the eviction order.
+// caller must hold px.mu.
+func (px *Paxos) forgetUniqueLocked(key string) {
+	delete(px.uniques, key)
+	for i, k := range px.uniqueKeys {
+		if k == key {
+			px.uniqueKeys = append(px.uniqueKeys[:i], px.uniqueKeys[i+1:]...)
+			break
+		}
+	}
+}
+
+// drop keys that are too old, and the oldest keys if there
+// are too many. caller must hold px.mu.
+func (px *Paxos) expireUniquesLocked() {
+	ttl := px.config.UniqueTTL
+	if ttl <= 0 {
+		ttl = defaultUniqueTTL
+	}
+	max := px.config.UniqueKeys
+	if max <= 0 {
+		max = defaultUniqueKeys
+	}
+
+	now := px.clock.Now()
+	n := 0
+	for _, key := range px.uniqueKeys {
+		e := px.uniques[key]
+		if now.Sub(e.at) < ttl && len(px.uniqueKeys)-n <= max {
+			break
+		}
+		delete(px.uniques, key)
+		n++
+	}
+	px.uniqueKeys = px.uniqueKeys[n:]
+}
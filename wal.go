@@ -0,0 +1,316 @@
+package paxos
+
+//
+// a write-ahead log of the acceptor's state, for Config.WAL.
+//
+// every change to an instance's promise, accept or decision
+// appends a record of the instance to the end of the log,
+// and the handler that made it fsyncs before replying, so a
+// peer never tells a proposer about a promise it could
+// forget in a crash. handlers running at the same time
+// share an fsync: whichever syncs first covers the records
+// the others appended. a peer restarted with the same WAL
+// replays it, the last record for each seq winning.
+// Config.Durability doesn't relax the fsync; it only says
+// how the Persister is used.
+//
+// the log only grows until CompactWAL() rewrites it with
+// just the live instances, dropping the forgotten ones.
+//
+// each record is its length, a CRC of its bytes, and the
+// bytes, a gob of walRecord. a crash in the middle of an
+// append leaves a torn record at the end, which OpenWAL()
+// cuts off, along with anything after it.
+//
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+type walRecord struct {
+	Seq       int
+	NP        string // n_p
+	NA        string // n_a
+	V         interface{}
+	State     Fate
+	DecidedAt time.Time
+}
+
+type WAL struct {
+	mu        sync.Mutex
+	path      string
+	f         *os.File
+	w         *bufio.Writer
+	dirty     bool        // appended to since the last sync
+	recovered []walRecord // what OpenWAL() found, for the peer to replay
+}
+
+//
+// open the WAL at path, creating it if need be, and read
+// back what's in it for a peer to replay.
+//
+func OpenWAL(path string) (*WAL, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	recs, end, err := readWAL(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// drop a torn tail, so appends follow the good records.
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &WAL{path: path, f: f, w: bufio.NewWriter(f), recovered: recs}, nil
+}
+
+//
+// flush and close the log. a peer using it must be dead.
+//
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.w.Flush(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// read records from the start of f, returning them and the
+// offset just past the last good one.
+func readWAL(f *os.File) ([]walRecord, int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	r := bufio.NewReader(f)
+	var recs []walRecord
+	var end int64
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return recs, end, nil
+		}
+		n := binary.BigEndian.Uint32(hdr[0:4])
+		sum := binary.BigEndian.Uint32(hdr[4:8])
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return recs, end, nil
+		}
+		if crc32.ChecksumIEEE(data) != sum {
+			return recs, end, nil
+		}
+		var rec walRecord
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&rec); err != nil {
+			return recs, end, nil
+		}
+		recs = append(recs, rec)
+		end += int64(len(hdr)) + int64(n)
+	}
+}
+
+// the bytes of rec as it goes in the log.
+func encodeWALRecord(rec walRecord) ([]byte, error) {
+	var body bytes.Buffer
+	if err := gob.NewEncoder(&body).Encode(&rec); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 8, 8+body.Len())
+	binary.BigEndian.PutUint32(buf[0:4], uint32(body.Len()))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(body.Bytes()))
+	return append(buf, body.Bytes()...), nil
+}
+
+// add rec to the end of the log, without syncing.
+func (w *WAL) append(rec walRecord) error {
+	data, err := encodeWALRecord(rec)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.w.Write(data); err != nil {
+		return err
+	}
+	w.dirty = true
+	return nil
+}
+
+// make everything appended so far durable.
+func (w *WAL) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.dirty {
+		// someone else's sync covered our records.
+		return nil
+	}
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+// replace the log with recs, atomically.
+func (w *WAL) rewrite(recs []walRecord) error {
+	tmp, err := os.Create(w.path + ".tmp")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(tmp)
+	for _, rec := range recs {
+		data, err := encodeWALRecord(rec)
+		if err == nil {
+			_, err = bw.Write(data)
+		}
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := os.Rename(tmp.Name(), w.path); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	w.f.Close()
+	w.f = tmp
+	w.w = bufio.NewWriter(tmp)
+	w.dirty = false
+	return nil
+}
+
+// append instance seq's state to Config.WAL, if there is
+// one. caller must hold px.mu, and call syncWAL() before
+// telling anyone about the change.
+func (px *Paxos) logInstanceLocked(seq int) {
+	if px.config.WAL == nil {
+		return
+	}
+	instance, ok := px.instances[seq]
+	if !ok {
+		return
+	}
+	rec := walRecord{Seq: seq, NP: instance.n_p, NA: instance.n_a, V: instance.v_a, State: instance.state,
+		DecidedAt: instance.decidedAt}
+	if err := px.config.WAL.append(rec); err != nil {
+		px.logf("Paxos(%v) can't log instance %v: %v", px.me, seq, err)
+	}
+}
+
+// make what's been logged durable. doesn't need px.mu, and
+// is best called without it, so that other handlers can
+// append while this one waits for the disk.
+func (px *Paxos) syncWAL() {
+	if px.config.WAL == nil {
+		return
+	}
+	if err := px.config.WAL.sync(); err != nil {
+		px.logf("Paxos(%v) can't sync the WAL: %v", px.me, err)
+	}
+}
+
+// rebuild the instances from what OpenWAL() read back.
+func (px *Paxos) replayWAL() {
+	w := px.config.WAL
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	recs := w.recovered
+	w.recovered = nil
+	w.mu.Unlock()
+
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	for _, rec := range recs {
+		if px.spilled[rec.Seq] {
+			continue // see loadSpilled()
+		}
+		instance, err := px.instanceLocked(rec.Seq, false)
+		if err != nil {
+			px.logf("Paxos(%v) instance %v: %v", px.me, rec.Seq, err)
+			continue
+		}
+		instance.n_p = rec.NP
+		instance.n_a = rec.NA
+		instance.v_a = rec.V
+		instance.state = rec.State
+		if rec.State == Decided && instance.decidedAt.IsZero() {
+			instance.decidedAt = rec.DecidedAt
+			if instance.decidedAt.IsZero() {
+				instance.decidedAt = px.clock.Now()
+			}
+		}
+	}
+}
+
+//
+// rewrite Config.WAL with just the live instances, dropping
+// the records of forgotten ones and the superseded records
+// of live ones. spilled instances are live too, and are
+// read back from the Persister to be kept; if one can't be,
+// the WAL is left as it is. call it now and then, after
+// Done(); handlers wait while it runs.
+//
+func (px *Paxos) CompactWAL() error {
+	if px.config.WAL == nil {
+		return nil
+	}
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	min := px.minLocked()
+	var recs []walRecord
+	for seq, instance := range px.instances {
+		if seq < min {
+			continue
+		}
+		recs = append(recs, walRecord{Seq: seq, NP: instance.n_p, NA: instance.n_a, V: instance.v_a, State: instance.state,
+			DecidedAt: instance.decidedAt})
+	}
+	for seq := range px.spilled {
+		if seq < min {
+			continue
+		}
+		instance, err := px.unspillLocked(seq)
+		if err != nil {
+			return err
+		}
+		recs = append(recs, walRecord{Seq: seq, NP: instance.n_p, NA: instance.n_a, V: instance.v_a, State: instance.state,
+			DecidedAt: instance.decidedAt})
+	}
+	return px.config.WAL.rewrite(recs)
+}